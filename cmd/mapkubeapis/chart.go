@@ -0,0 +1,125 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"log"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+
+	"github.com/hickeyma/helm-mapkubeapis/pkg/common"
+	v3 "github.com/hickeyma/helm-mapkubeapis/pkg/v3"
+)
+
+// Name and namespace of the release a chart is rendered for, as helm template uses by default
+const (
+	chartReleaseName      = "RELEASE-NAME"
+	chartReleaseNamespace = "default"
+)
+
+func newChartCmd(out io.Writer) *cobra.Command {
+	valueOpts := &values.Options{}
+
+	cmd := &cobra.Command{
+		Use:   "chart [flags] CHART",
+		Short: "Check a local chart for deprecated or removed Kubernetes APIs",
+		Long: "Render a local chart, a directory or an archive, with the Helm v3 engine for the Kubernetes version " +
+			"and the values, and check the rendered resources for deprecated or removed Kubernetes APIs with the " +
+			"same mappings as releases. A report of the resources found is printed with the template each is from, " +
+			"and the changes needed to the templates are shown. The cluster is not accessed, so --kube-version " +
+			"is required. The command exits with the same codes as the check command.",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mapOptions, _, err := newMapOptions(nil)
+			if err != nil {
+				return err
+			}
+			return CheckChart(out, mapOptions, args[0], valueOpts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringSliceVarP(&valueOpts.ValueFiles, "values", "f", []string{}, "values file to render the chart with (can specify multiple)")
+	flags.StringArrayVar(&valueOpts.Values, "set", []string{}, "value to render the chart with, as key1=val1,key2=val2 (can specify multiple)")
+	flags.StringArrayVar(&valueOpts.StringValues, "set-string", []string{}, "string value to render the chart with, as key1=val1,key2=val2 (can specify multiple)")
+
+	return cmd
+}
+
+// CheckChart renders the chart with the values and checks it for Kubernetes deprecated or removed APIs,
+// and writes a report of the resources found to out. It returns an exitError if any resources use APIs
+// deprecated or removed in the Kubernetes version.
+func CheckChart(out io.Writer, mapOptions MapOptions, chartPath string, valueOpts *values.Options) error {
+	if mapOptions.KubeVersion == "" {
+		return errors.New("the --kube-version flag is required, as the cluster is not accessed")
+	}
+	if mapOptions.Output == "" {
+		mapOptions.Output = outputTable
+	}
+	namespace := mapOptions.ReleaseNamespace
+	if namespace == "" {
+		namespace = chartReleaseNamespace
+	}
+
+	options := common.MapOptions{
+		Color:            mapOptions.Color,
+		DryRun:           true,
+		KubeVersion:      mapOptions.KubeVersion,
+		MapFile:          mapOptions.MapFile,
+		Policy:           mapOptions.Policy,
+		ReleaseName:      chartReleaseName,
+		ReleaseNamespace: namespace,
+		SkipAPIDiscovery: true,
+	}
+	kubeVersion, err := common.GetKubernetesVersion(options)
+	if err != nil {
+		return err
+	}
+	options.KubeVersion = kubeVersion
+
+	// values files are only read locally, as the plugin has no Helm repository settings
+	vals, err := valueOpts.MergeValues(getter.Providers{})
+	if err != nil {
+		return errors.Wrap(err, "failed to read values")
+	}
+	releaseReport, err := v3.CheckChart(chartPath, vals, options)
+	if err != nil {
+		return err
+	}
+
+	report := &common.Report{DryRun: true, KubeVersion: kubeVersion, Releases: []common.ReleaseReport{*releaseReport}}
+	if mapOptions.Output == outputTable {
+		err = writeFindingsTable(out, releaseReport.Resources)
+	} else {
+		err = writeReport(out, report, mapOptions.Output)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := checkResult(report); err != nil {
+		return err
+	}
+	log.Printf("No resources of chart '%s' use APIs deprecated or removed in Kubernetes %s.\n", chartPath, kubeVersion)
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+// Exit codes of the check command. Errors exit with 1.
+const (
+	checkExitDeprecated = 2
+	checkExitRemoved    = 3
+)
+
+// exitError is an error which exits the plugin with its code
+type exitError struct {
+	code int
+	msg  string
+}
+
+func (e *exitError) Error() string {
+	return e.msg
+}
+
+func newCheckCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check [flags] [RELEASE]",
+		Short: "Check releases for deprecated or removed Kubernetes APIs without updating them",
+		Long: "Check releases for deprecated or removed Kubernetes APIs and print a report of the resources found. " +
+			"The releases are never updated. The command exits with 0 if no resources use deprecated or removed APIs, " +
+			"2 if resources only use deprecated APIs, and 3 if resources use APIs removed in the Kubernetes version. " +
+			"It exits with 1 on errors. The report is printed as a table, unless --output is set.",
+		SilenceUsage: true,
+		Args:         validateReleaseArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCheck(out, args)
+		},
+	}
+
+	settings.AddReleaseFlags(cmd.Flags())
+
+	return cmd
+}
+
+func runCheck(out io.Writer, args []string) error {
+	mapOptions, kubeConfig, err := newMapOptions(args)
+	if err != nil {
+		return err
+	}
+	return Check(out, mapOptions, kubeConfig)
+}
+
+// Check checks for Kubernetes deprecated or removed APIs in the manifest of the last deployed release
+// version, without updating the release, and writes a report of the resources found to out. It returns
+// an exitError if any resources use APIs deprecated or removed in the Kubernetes version.
+func Check(out io.Writer, mapOptions MapOptions, kubeConfig common.KubeConfig) error {
+	mapOptions.CheckOnly = true
+	mapOptions.DryRun = true
+	if mapOptions.Output == "" {
+		mapOptions.Output = outputTable
+	}
+
+	report, err := mapReleases(mapOptions, kubeConfig)
+	if report == nil {
+		return err
+	}
+	if writeErr := writeReport(out, report, mapOptions.Output); writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := checkResult(report); err != nil {
+		return err
+	}
+	log.Printf("No resources use APIs deprecated or removed in Kubernetes %s.\n", report.KubeVersion)
+	return nil
+}
+
+// checkResult returns an exitError if any resources in the report use APIs deprecated or removed
+// in the Kubernetes version
+func checkResult(report *common.Report) error {
+	var deprecated, removed int
+	for _, rel := range report.Releases {
+		for _, res := range rel.Resources {
+			if res.Removed {
+				removed++
+			} else if res.Deprecated {
+				deprecated++
+			}
+		}
+	}
+	switch {
+	case removed > 0:
+		return &exitError{code: checkExitRemoved, msg: fmt.Sprintf("found %d resource(s) using APIs removed in Kubernetes %s", removed, report.KubeVersion)}
+	case deprecated > 0:
+		return &exitError{code: checkExitDeprecated, msg: fmt.Sprintf("found %d resource(s) using APIs deprecated in Kubernetes %s", deprecated, report.KubeVersion)}
+	}
+	return nil
+}
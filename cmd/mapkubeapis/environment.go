@@ -18,16 +18,34 @@ package main
 
 import (
 	"github.com/spf13/pflag"
+
+	"github.com/hickeyma/helm-mapkubeapis/pkg/common"
+	"github.com/hickeyma/helm-mapkubeapis/pkg/mapping"
 )
 
 // EnvSettings defined settings
 type EnvSettings struct {
+	AllNamespaces    bool
+	AllReleases      bool
+	AllRevisions     bool
+	BackupDir        string
+	Color            bool
+	ConflictRetries  int
 	DryRun           bool
+	ExcludeReleases  []string
+	FailedRevision   string
 	KubeConfigFile   string
 	KubeContext      string
+	KubeVersion      string
 	MapFile          string
+	MatchCharts      []string
+	MatchReleases    []string
 	Namespace        string
+	Output           string
+	Policy           string
 	RunV2            bool
+	Selector         string
+	SkipAPIDiscovery bool
 	StorageType      string
 	TillerOutCluster bool
 }
@@ -41,16 +59,39 @@ func New() *EnvSettings {
 // AddBaseFlags binds base flags to the given flagset.
 func (s *EnvSettings) AddBaseFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&s.DryRun, "dry-run", false, "simulate a command")
+	fs.BoolVar(&s.Color, "color", false, "color the diffs of the manifest changes shown in dry-run mode")
 }
 
 // AddFlags binds flags to the given flagset.
 func (s *EnvSettings) AddFlags(fs *pflag.FlagSet) {
 	s.AddBaseFlags(fs)
+	fs.BoolVar(&s.SkipAPIDiscovery, "skip-api-discovery", false, "do not check with the discovery API of the Kubernetes server that it serves the APIs of the manifest, before the release is updated and to report the resources no mapping maps. The check before the release is updated is skipped when --kube-version is not the version of the server")
 	fs.StringVar(&s.KubeConfigFile, "kubeconfig", "", "path to the kubeconfig file")
 	fs.StringVar(&s.KubeContext, "kube-context", s.KubeContext, "name of the kubeconfig context to use")
+	fs.StringVar(&s.KubeVersion, "kube-version", s.KubeVersion, "Kubernetes version to map the APIs for e.g. v1.22. When set, the version of the Kubernetes server is not looked up")
 	fs.StringVar(&s.MapFile, "mapfile", s.MapFile, "path to the API mapping file")
 	fs.StringVar(&s.Namespace, "namespace", s.Namespace, "namespace scope of the release. For Helm v2, this is the Tiller namespace e.g. kube-system")
+	fs.StringVarP(&s.Output, "output", "o", "", "print a report of the resources found using deprecated or removed APIs. It can be 'json', 'yaml' or 'table'")
+	fs.StringVar(&s.Policy, "policy", mapping.PolicyDeprecated, "when APIs are mapped. It can be 'deprecated' to map APIs from the Kubernetes version they are deprecated in, or 'removed' to map them only from the version they are removed in. Mappings can override it with their own policy")
 	fs.BoolVar(&s.RunV2, "v2", false, "run for Helm v2 release. The default is Helm v3.")
 	fs.BoolVar(&s.TillerOutCluster, "tiller-out-cluster", false, "for Helm v2 only - when Tiller is not running in the cluster e.g. Tillerless")
 	fs.StringVarP(&s.StorageType, "release-storage", "s", "secrets", "for Helm v2 only - release storage type/object. It can be 'secrets' or 'configmaps'. This is only used with the 'tiller-out-cluster' flag")
 }
+
+// AddReleaseFlags binds the flags which select the releases and revisions to map to the given flagset.
+func (s *EnvSettings) AddReleaseFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&s.AllReleases, "all", false, "map all releases in the namespace set by --namespace instead of a single release")
+	fs.BoolVarP(&s.AllNamespaces, "all-namespaces", "A", false, "map all releases in all namespaces instead of a single release. For Helm v2, this maps all releases of the Tiller")
+	fs.BoolVar(&s.AllRevisions, "all-revisions", false, "map every stored revision of the release in place, keeping their revision numbers and statuses, instead of adding a new revision with the latest revision mapped")
+	fs.StringVarP(&s.Selector, "selector", "l", "", "with --all or --all-namespaces, only map releases whose storage objects match the label selector e.g. team=a. Only equality-based requirements are supported")
+	fs.StringSliceVar(&s.MatchReleases, "match", nil, "with --all or --all-namespaces, only map releases whose name matches one of the glob patterns e.g. team-a-*")
+	fs.StringSliceVar(&s.MatchCharts, "chart", nil, "with --all or --all-namespaces, only map releases of one of the charts")
+	fs.StringSliceVar(&s.ExcludeReleases, "exclude", nil, "with --all or --all-namespaces, do not map releases whose name matches one of the glob patterns")
+	fs.IntVar(&s.ConflictRetries, "conflict-retries", 0, "number of times to read and map a release again when it was changed by another client while it was being mapped e.g. by a concurrent helm upgrade")
+	fs.StringVar(&s.FailedRevision, "failed-revision", common.FailedRevisionRefuse, "how to map a release whose latest revision is failed or pending. It can be 'refuse' to not map the release, 'deployed' to map the last deployed revision and leave the later revisions as they are, or 'delete' to delete the later revisions and map the last deployed revision")
+}
+
+// AddBackupFlags binds the flags of the release backups to the given flagset.
+func (s *EnvSettings) AddBackupFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&s.BackupDir, "backup-dir", s.BackupDir, "directory where the release storage objects are backed up before they are updated")
+}
@@ -0,0 +1,176 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hickeyma/helm-mapkubeapis/pkg/common"
+	v2 "github.com/hickeyma/helm-mapkubeapis/pkg/v2"
+	v3 "github.com/hickeyma/helm-mapkubeapis/pkg/v3"
+)
+
+func newFileCmd(out io.Writer) *cobra.Command {
+	var outputFile string
+
+	cmd := &cobra.Command{
+		Use:   "file [flags] PATH",
+		Short: "Map deprecated or removed Kubernetes APIs in release storage objects exported to a file",
+		Long: "Map the deprecated or removed Kubernetes APIs of the releases in the release storage objects " +
+			"(Secrets or ConfigMaps) of a file, as exported with 'kubectl get -o yaml', and write the storage " +
+			"objects with the mapped releases, ready to be applied with 'kubectl apply'. Helm v2 and v3 storage " +
+			"objects are supported. The cluster is not accessed, so --kube-version is required. The storage " +
+			"objects are written to standard output, unless --output-file is set.",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mapOptions, _, err := newMapOptions(nil)
+			if err != nil {
+				return err
+			}
+			return MapFile(out, mapOptions, args[0], outputFile)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&outputFile, "output-file", "", "path to the file to write the storage objects with the mapped releases to. The default is standard output")
+
+	return cmd
+}
+
+// MapFile maps the deprecated or removed APIs of the releases in the release storage objects of a file,
+// and writes the storage objects with the mapped releases to the output file, or out if it is not set.
+// The storage objects are updated in place, so that they keep their release versions.
+func MapFile(out io.Writer, mapOptions MapOptions, path, outputFile string) error {
+	if mapOptions.KubeVersion == "" {
+		return errors.New("the --kube-version flag is required, as the cluster is not accessed")
+	}
+	if mapOptions.Output != "" && outputFile == "" && !mapOptions.DryRun {
+		return errors.New("the --output-file flag is required with --output, as both are written to standard output")
+	}
+	if mapOptions.DryRun {
+		log.Println("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		log.Println("Run without --dry-run to take the actions described below:")
+		log.Println()
+	}
+
+	options := common.MapOptions{
+		Color:            mapOptions.Color,
+		DryRun:           mapOptions.DryRun,
+		KubeVersion:      mapOptions.KubeVersion,
+		MapFile:          mapOptions.MapFile,
+		PluginVersion:    version,
+		Policy:           mapOptions.Policy,
+		SkipAPIDiscovery: true,
+	}
+	kubeVersion, err := common.GetKubernetesVersion(options)
+	if err != nil {
+		return err
+	}
+	options.KubeVersion = kubeVersion
+
+	objects, err := common.ReadStorageObjects(path)
+	if err != nil {
+		return err
+	}
+	log.Printf("Found %d release storage object(s) in file: %s\n", len(objects), path)
+
+	report := &common.Report{DryRun: options.DryRun, KubeVersion: kubeVersion, Releases: []common.ReleaseReport{}}
+	var mapped int
+	for _, object := range objects {
+		releaseReport, changed, err := mapStorageObject(object, options)
+		if err != nil {
+			return errors.Wrapf(err, "failed to map storage object '%s'", object.Name())
+		}
+		report.Releases = append(report.Releases, *releaseReport)
+		if changed {
+			mapped++
+		}
+	}
+	log.Printf("Deprecated or removed APIs mapped in %d of %d release storage object(s).\n", mapped, len(objects))
+
+	if !options.DryRun {
+		if err := writeStorageObjects(out, objects, outputFile); err != nil {
+			return err
+		}
+	}
+	if mapOptions.Output != "" {
+		return writeReport(out, report, mapOptions.Output)
+	}
+	return nil
+}
+
+// mapStorageObject maps the release in the storage object and clears the metadata fields set by the API
+// server, so that the storage object can be applied. It returns a report of the release and whether it changed.
+func mapStorageObject(object common.StorageObject, options common.MapOptions) (*common.ReleaseReport, bool, error) {
+	helmVersion, err := object.HelmVersion()
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := object.ReleaseData()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var mappedData string
+	var report *common.ReleaseReport
+	if helmVersion == "v2" {
+		mappedData, report, err = v2.MapReleaseData(data, options)
+	} else {
+		mappedData, report, err = v3.MapReleaseData(data, options)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	object.ClearServerFields()
+	if mappedData == data {
+		return report, false, nil
+	}
+	object.SetReleaseData(mappedData)
+	provenance, err := common.NewProvenance(options, report)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := object.SetProvenance(provenance); err != nil {
+		return nil, false, err
+	}
+	return report, true, nil
+}
+
+// writeStorageObjects writes the storage objects to the output file, or out if it is not set
+func writeStorageObjects(out io.Writer, objects []common.StorageObject, outputFile string) error {
+	if outputFile == "" {
+		return common.WriteStorageObjects(out, objects)
+	}
+	var buf bytes.Buffer
+	if err := common.WriteStorageObjects(&buf, objects); err != nil {
+		return err
+	}
+	// the storage objects hold the release values, which may be secret
+	if err := ioutil.WriteFile(outputFile, buf.Bytes(), 0600); err != nil {
+		return errors.Wrapf(err, "failed to write file: %s", outputFile)
+	}
+	log.Printf("Release storage objects written to: %s\n", outputFile)
+	return nil
+}
@@ -18,25 +18,42 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/helmpath"
 
 	"github.com/hickeyma/helm-mapkubeapis/pkg/common"
+	"github.com/hickeyma/helm-mapkubeapis/pkg/mapping"
 	v2 "github.com/hickeyma/helm-mapkubeapis/pkg/v2"
 	v3 "github.com/hickeyma/helm-mapkubeapis/pkg/v3"
 )
 
 // MapOptions contains the options for Map operation
 type MapOptions struct {
+	AllNamespaces    bool
+	AllReleases      bool
+	AllRevisions     bool
+	BackupDir        string
+	CheckOnly        bool
+	Color            bool
+	ConflictRetries  int
 	DryRun           bool
+	FailedRevision   string
+	KubeVersion      string
 	MapFile          string
+	Output           string
+	Policy           string
+	ReleaseFilter    common.ReleaseFilter
 	ReleaseName      string
 	ReleaseNamespace string
 	RunV2            bool
+	SkipAPIDiscovery bool
 	StorageType      string
 	TillerOutCluster bool
 }
@@ -47,21 +64,15 @@ var (
 
 func newMapCmd(out io.Writer, args []string) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:          "mapkubeapis [flags] RELEASE",
+		Use:          "mapkubeapis [flags] [RELEASE]",
 		Short:        "Map release deprecated or removed Kubernetes APIs in-place",
 		Long:         "Map release deprecated or removed Kubernetes APIs in-place",
 		SilenceUsage: true,
-		Args: func(cmd *cobra.Command, args []string) error {
-			if len(args) == 0 {
-				cmd.Help()
-				os.Exit(1)
-			} else if len(args) > 1 {
-				return errors.New("only one release name may be passed at a time")
-			}
-			return nil
-		},
+		Args:         validateReleaseArgs,
 
-		RunE: runMap,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runMap(out, args)
+		},
 	}
 
 	flags := cmd.PersistentFlags()
@@ -75,6 +86,9 @@ func newMapCmd(out io.Writer, args []string) *cobra.Command {
 		settings.MapFile = filepath.Join("config", "Map.yaml")
 	}
 
+	// Get the default backup directory
+	settings.BackupDir = helmpath.DataPath("mapkubeapis", "backups")
+
 	// When run with the Helm plugin framework, Helm plugins are not passed the
 	// plugin flags that correspond to Helm global flags e.g. helm mapkubeapis v3map --kube-context ...
 	// The flag values are set to corresponding environment variables instead.
@@ -88,18 +102,90 @@ func newMapCmd(out io.Writer, args []string) *cobra.Command {
 	// the KUBECONFIG environment variable instead of being passed into the plugin.
 
 	settings.AddFlags(flags)
+	settings.AddReleaseFlags(cmd.Flags())
+	settings.AddBackupFlags(cmd.Flags())
+
+	cmd.AddCommand(newChartCmd(out))
+	cmd.AddCommand(newCheckCmd(out))
+	cmd.AddCommand(newFileCmd(out))
+	cmd.AddCommand(newPostRenderCmd(out))
+	cmd.AddCommand(newRenderCmd(out))
+	cmd.AddCommand(newRestoreCmd(out))
+	cmd.AddCommand(newValidateMapfileCmd(out))
 
 	return cmd
 }
 
-func runMap(cmd *cobra.Command, args []string) error {
-	releaseName := args[0]
+// validateReleaseArgs checks that a single release name is passed, unless all releases are selected
+func validateReleaseArgs(cmd *cobra.Command, args []string) error {
+	if settings.AllReleases || settings.AllNamespaces {
+		if len(args) > 0 {
+			return errors.New("a release name cannot be passed with the --all or --all-namespaces flags")
+		}
+		return nil
+	}
+	if settings.Selector != "" || len(settings.MatchReleases) > 0 || len(settings.MatchCharts) > 0 || len(settings.ExcludeReleases) > 0 {
+		return errors.New("the --selector, --match, --chart and --exclude flags can only be used with the --all or --all-namespaces flags")
+	}
+	if len(args) == 0 {
+		cmd.Help()
+		os.Exit(1)
+	} else if len(args) > 1 {
+		return errors.New("only one release name may be passed at a time")
+	}
+	return nil
+}
+
+func runMap(out io.Writer, args []string) error {
+	mapOptions, kubeConfig, err := newMapOptions(args)
+	if err != nil {
+		return err
+	}
+	return Map(out, mapOptions, kubeConfig)
+}
+
+// newMapOptions returns the options for the release passed, if any, from the settings
+func newMapOptions(args []string) (MapOptions, common.KubeConfig, error) {
+	if !mapping.IsValidPolicy(settings.Policy) {
+		return MapOptions{}, common.KubeConfig{}, fmt.Errorf("invalid --policy '%s', it can be '%s' or '%s'", settings.Policy, mapping.PolicyDeprecated, mapping.PolicyRemoved)
+	}
+	if err := validateOutput(settings.Output); err != nil {
+		return MapOptions{}, common.KubeConfig{}, err
+	}
+	if !common.IsValidFailedRevision(settings.FailedRevision) {
+		return MapOptions{}, common.KubeConfig{}, fmt.Errorf("invalid --failed-revision '%s', it can be '%s', '%s' or '%s'", settings.FailedRevision,
+			common.FailedRevisionRefuse, common.FailedRevisionDeployed, common.FailedRevisionDelete)
+	}
+	if settings.ConflictRetries < 0 {
+		return MapOptions{}, common.KubeConfig{}, fmt.Errorf("invalid --conflict-retries '%d', it cannot be negative", settings.ConflictRetries)
+	}
+	var releaseName string
+	if len(args) > 0 {
+		releaseName = args[0]
+	}
 	mapOptions := MapOptions{
-		DryRun:           settings.DryRun,
-		MapFile:          settings.MapFile,
+		AllNamespaces:   settings.AllNamespaces,
+		AllReleases:     settings.AllReleases,
+		AllRevisions:    settings.AllRevisions,
+		BackupDir:       settings.BackupDir,
+		Color:           settings.Color,
+		ConflictRetries: settings.ConflictRetries,
+		DryRun:          settings.DryRun,
+		FailedRevision:  settings.FailedRevision,
+		KubeVersion:     settings.KubeVersion,
+		MapFile:         settings.MapFile,
+		Output:          settings.Output,
+		Policy:          settings.Policy,
+		ReleaseFilter: common.ReleaseFilter{
+			Selector: settings.Selector,
+			Names:    settings.MatchReleases,
+			Charts:   settings.MatchCharts,
+			Exclude:  settings.ExcludeReleases,
+		},
 		ReleaseName:      releaseName,
 		ReleaseNamespace: settings.Namespace,
 		RunV2:            settings.RunV2,
+		SkipAPIDiscovery: settings.SkipAPIDiscovery,
 		StorageType:      settings.StorageType,
 		TillerOutCluster: settings.TillerOutCluster,
 	}
@@ -107,47 +193,206 @@ func runMap(cmd *cobra.Command, args []string) error {
 		Context: settings.KubeContext,
 		File:    settings.KubeConfigFile,
 	}
-
-	return Map(mapOptions, kubeConfig)
+	return mapOptions, kubeConfig, nil
 }
 
 // Map checks for Kubernetes deprecated or removed APIs in the manifest of the last deployed release version
 // and maps those API versions to supported versions. It then adds a new release version with
 // the updated APIs and supersedes the version with the unsupported APIs.
-func Map(mapOptions MapOptions, kubeConfig common.KubeConfig) error {
+// When all releases are selected, every release in the namespace (or all namespaces) is mapped
+// and an error is returned if any of them failed. When an output format is set, a report of
+// the resources found using deprecated or removed APIs is written to out.
+func Map(out io.Writer, mapOptions MapOptions, kubeConfig common.KubeConfig) error {
 	if mapOptions.DryRun {
 		log.Println("NOTE: This is in dry-run mode, the following actions will not be executed.")
 		log.Println("Run without --dry-run to take the actions described below:")
 		log.Println()
 	}
 
-	log.Printf("Release '%s' will be checked for deprecated or removed Kubernetes APIs and will be updated if necessary to supported API versions.\n", mapOptions.ReleaseName)
+	report, err := mapReleases(mapOptions, kubeConfig)
+	if report != nil && mapOptions.Output != "" {
+		if writeErr := writeReport(out, report, mapOptions.Output); writeErr != nil {
+			return writeErr
+		}
+	}
+	return err
+}
 
+// mapReleases maps, or only checks, the releases selected by the options. It returns a report of the
+// releases checked, which is nil if none could be checked.
+func mapReleases(mapOptions MapOptions, kubeConfig common.KubeConfig) (*common.Report, error) {
 	options := common.MapOptions{
+		BackupDir:        mapOptions.BackupDir,
+		Color:            mapOptions.Color,
+		ConflictRetries:  mapOptions.ConflictRetries,
 		DryRun:           mapOptions.DryRun,
+		FailedRevision:   mapOptions.FailedRevision,
 		KubeConfig:       kubeConfig,
+		KubeVersion:      mapOptions.KubeVersion,
 		MapFile:          mapOptions.MapFile,
+		PluginVersion:    version,
+		Policy:           mapOptions.Policy,
 		ReleaseName:      mapOptions.ReleaseName,
 		ReleaseNamespace: mapOptions.ReleaseNamespace,
+		SkipAPIDiscovery: mapOptions.SkipAPIDiscovery,
 		StorageType:      mapOptions.StorageType,
 		TillerOutCluster: mapOptions.TillerOutCluster,
 	}
 
-	if mapOptions.RunV2 {
-		// default namespace to the Tiller default namespace
-		if options.ReleaseNamespace == "" {
-			options.ReleaseNamespace = "kube-system"
+	// default namespace to the Tiller default namespace
+	if mapOptions.RunV2 && options.ReleaseNamespace == "" {
+		options.ReleaseNamespace = "kube-system"
+	}
+
+	options.KubeVersionFromServer = options.KubeVersion == ""
+	// get the Kubernetes version once, instead of for every release
+	kubeVersion, err := common.GetKubernetesVersion(options)
+	if err != nil {
+		return nil, err
+	}
+	options.KubeVersion = kubeVersion
+
+	report := &common.Report{DryRun: options.DryRun, KubeVersion: kubeVersion, Releases: []common.ReleaseReport{}}
+	if mapOptions.AllReleases || mapOptions.AllNamespaces {
+		if err := mapAllReleases(options, mapOptions, report); err != nil {
+			// the releases which failed to map are in the report, unless none could be checked
+			if len(report.Releases) == 0 {
+				return nil, err
+			}
+			return report, err
+		}
+		return report, nil
+	}
+
+	releaseReports, err := mapRelease(options, mapOptions.RunV2, mapOptions.CheckOnly, mapOptions.AllRevisions)
+	if err != nil {
+		return nil, err
+	}
+	report.Releases = append(report.Releases, releaseReports...)
+	return report, nil
+}
+
+// mapRelease maps, or only checks, the release and returns its report. When all revisions are set, it maps
+// every release version and returns a report for each.
+func mapRelease(options common.MapOptions, runV2, checkOnly, allRevisions bool) ([]common.ReleaseReport, error) {
+	if allRevisions {
+		return mapReleaseHistory(options, runV2, checkOnly)
+	}
+
+	if checkOnly {
+		log.Printf("Release '%s' will be checked for deprecated or removed Kubernetes APIs.\n", options.ReleaseName)
+		var report *common.ReleaseReport
+		var err error
+		if runV2 {
+			report, err = v2.CheckReleaseForUnSupportedAPIs(options)
+		} else {
+			report, err = v3.CheckReleaseForUnSupportedAPIs(options)
 		}
-		if err := v2.MapReleaseWithUnSupportedAPIs(options); err != nil {
-			return err
+		if err != nil {
+			return nil, err
 		}
+		return []common.ReleaseReport{*report}, nil
+	}
+
+	log.Printf("Release '%s' will be checked for deprecated or removed Kubernetes APIs and will be updated if necessary to supported API versions.\n", options.ReleaseName)
+
+	var report *common.ReleaseReport
+	var err error
+	if runV2 {
+		report, err = v2.MapReleaseWithUnSupportedAPIs(options)
 	} else {
-		if err := v3.MapReleaseWithUnSupportedAPIs(options); err != nil {
-			return err
+		report, err = v3.MapReleaseWithUnSupportedAPIs(options)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Map of release '%s' deprecated or removed APIs to supported versions, completed successfully.\n", options.ReleaseName)
+
+	return []common.ReleaseReport{*report}, nil
+}
+
+// mapReleaseHistory maps, or only checks, every release version of the release in place and returns a report for each
+func mapReleaseHistory(options common.MapOptions, runV2, checkOnly bool) ([]common.ReleaseReport, error) {
+	if checkOnly {
+		log.Printf("All versions of release '%s' will be checked for deprecated or removed Kubernetes APIs.\n", options.ReleaseName)
+		if runV2 {
+			return v2.CheckReleaseHistory(options)
 		}
+		return v3.CheckReleaseHistory(options)
+	}
+
+	log.Printf("All versions of release '%s' will be checked for deprecated or removed Kubernetes APIs and will be updated in place if necessary to supported API versions.\n", options.ReleaseName)
+
+	var reports []common.ReleaseReport
+	var err error
+	if runV2 {
+		reports, err = v2.MapReleaseHistory(options)
+	} else {
+		reports, err = v3.MapReleaseHistory(options)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Map of all versions of release '%s' deprecated or removed APIs to supported versions, completed successfully.\n", options.ReleaseName)
 
-	log.Printf("Map of release '%s' deprecated or removed APIs to supported versions, completed successfully.\n", mapOptions.ReleaseName)
+	return reports, nil
+}
+
+// mapAllReleases maps every release in storage selected by the filter, adds the result for each release
+// to the report and logs a summary of them. For Helm v2, the releases of all namespaces are stored
+// in the Tiller namespace.
+func mapAllReleases(options common.MapOptions, mapOptions MapOptions, report *common.Report) error {
+	filter, runV2, checkOnly := mapOptions.ReleaseFilter, mapOptions.RunV2, mapOptions.CheckOnly
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	var releases []common.ReleaseRef
+	var err error
+	if runV2 {
+		releases, err = v2.ListReleases(options, filter)
+	} else {
+		releases, err = v3.ListReleases(options, mapOptions.AllNamespaces, filter)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Found %d release(s) to check for deprecated or removed Kubernetes APIs.\n", len(releases))
 
+	action := "Map"
+	if checkOnly {
+		action = "Check"
+	}
+
+	results := make([]error, len(releases))
+	var failed int
+	for i, rel := range releases {
+		releaseOptions := options
+		releaseOptions.ReleaseName = rel.Name
+		releaseOptions.ReleaseNamespace = rel.Namespace
+		releaseReports, err := mapRelease(releaseOptions, runV2, checkOnly, mapOptions.AllRevisions)
+		if err != nil {
+			log.Printf("%s of release '%s' in namespace '%s' failed: %s\n", action, rel.Name, rel.Namespace, err)
+			results[i] = err
+			failed++
+			releaseReports = []common.ReleaseReport{{Release: rel.Name, Namespace: rel.Namespace, Resources: []common.Finding{}, Error: err.Error()}}
+		}
+		report.Releases = append(report.Releases, releaseReports...)
+	}
+
+	log.Println("Summary of the releases checked:")
+	for i, rel := range releases {
+		if results[i] != nil {
+			log.Printf("  %s/%s: failed: %s\n", rel.Namespace, rel.Name, results[i])
+		} else {
+			log.Printf("  %s/%s: succeeded\n", rel.Namespace, rel.Name)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to %s %d of %d release(s)", strings.ToLower(action), failed, len(releases))
+	}
+	log.Printf("%s of %d release(s) completed successfully.\n", action, len(releases))
 	return nil
 }
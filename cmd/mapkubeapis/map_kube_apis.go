@@ -17,13 +17,21 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"os"
 )
 
+// version is the version of the plugin, set at build time
+var version = "dev"
+
 func main() {
 	mapCmd := newMapCmd(os.Stdout, os.Args[1:])
 
 	if err := mapCmd.Execute(); err != nil {
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,115 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	"github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+// Report output formats
+const (
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+)
+
+func validateOutput(output string) error {
+	switch output {
+	case "", outputJSON, outputYAML, outputTable:
+		return nil
+	}
+	return fmt.Errorf("invalid --output '%s', it can be '%s', '%s' or '%s'", output, outputJSON, outputYAML, outputTable)
+}
+
+// writeReport writes the report to out in the output format
+func writeReport(out io.Writer, report *common.Report, output string) error {
+	switch output {
+	case outputJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return errors.Wrap(encoder.Encode(report), "failed to encode report")
+	case outputYAML:
+		b, err := yaml.Marshal(report)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode report")
+		}
+		_, err = out.Write(b)
+		return err
+	case outputTable:
+		return writeReportTable(out, report)
+	}
+	return validateOutput(output)
+}
+
+func writeReportTable(out io.Writer, report *common.Report) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RELEASE\tNAMESPACE\tREVISION\tKIND\tNAME\tFROM\tTO\tAPI\tSTATUS")
+	for _, rel := range report.Releases {
+		switch {
+		case rel.Error != "":
+			fmt.Fprintf(w, "%s\t%s\t%d\t-\t-\t-\t-\t-\tfailed: %s\n", rel.Release, rel.Namespace, rel.Revision, rel.Error)
+		case len(rel.Resources) == 0:
+			fmt.Fprintf(w, "%s\t%s\t%d\t-\t-\t-\t-\t-\tnone found\n", rel.Release, rel.Namespace, rel.Revision)
+		}
+		for _, res := range rel.Resources {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", rel.Release, rel.Namespace, rel.Revision,
+				res.Kind, res.Name, res.FromAPI, tableCell(res.ToAPI), apiState(res), res.Status)
+		}
+	}
+	return w.Flush()
+}
+
+// writeFindingsTable writes the resources found in a manifest with the template each is from, if known
+func writeFindingsTable(out io.Writer, findings []common.Finding) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TEMPLATE\tKIND\tNAME\tFROM\tTO\tAPI\tSTATUS")
+	if len(findings) == 0 {
+		fmt.Fprintf(w, "-\t-\t-\t-\t-\t-\tnone found\n")
+	}
+	for _, res := range findings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", tableCell(res.Source), res.Kind, res.Name, res.FromAPI, tableCell(res.ToAPI), apiState(res), res.Status)
+	}
+	return w.Flush()
+}
+
+// tableCell returns the value of a table cell, which is "-" if the value is not set
+func tableCell(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// apiState describes if the API of a finding is removed or deprecated in the Kubernetes version checked
+func apiState(res common.Finding) string {
+	switch {
+	case res.Removed:
+		return "removed"
+	case res.Deprecated:
+		return "deprecated"
+	}
+	return "supported"
+}
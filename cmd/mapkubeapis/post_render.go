@@ -0,0 +1,106 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+func newPostRenderCmd(out io.Writer) *cobra.Command {
+	var logFile string
+
+	cmd := &cobra.Command{
+		Use:   "post-render [flags]",
+		Short: "Map deprecated or removed Kubernetes APIs as a Helm post-renderer",
+		Long: "Map the deprecated or removed Kubernetes APIs of the manifest rendered by 'helm install' or " +
+			"'helm upgrade', read from standard input, and write the mapped manifest to standard output. The " +
+			"mapped resources are annotated with the API they were mapped from, so the mappings are visible in " +
+			"the release. The APIs are mapped for the version of the Kubernetes server, unless --kube-version is " +
+			"set. Helm runs post-renderers without arguments, so use the scripts/post_renderer.sh wrapper of the " +
+			"plugin with --post-renderer.",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mapOptions, kubeConfig, err := newMapOptions(nil)
+			if err != nil {
+				return err
+			}
+			if logFile != "" {
+				f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+				if err != nil {
+					return errors.Wrapf(err, "failed to open log file: %s", logFile)
+				}
+				defer f.Close()
+				// Helm only shows the standard error of a post-renderer when it fails
+				log.SetOutput(io.MultiWriter(os.Stderr, f))
+			}
+			return PostRender(os.Stdin, out, mapOptions, kubeConfig)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&logFile, "log-file", "", "path to a file to append the log to, as Helm does not show the log of a post-renderer which succeeds")
+
+	return cmd
+}
+
+// PostRender maps the deprecated or removed APIs of the resources in the manifest read from in, annotates
+// the mapped resources with the API they were mapped from, and writes the mapped manifest to out
+func PostRender(in io.Reader, out io.Writer, mapOptions MapOptions, kubeConfig common.KubeConfig) error {
+	options := common.MapOptions{
+		AnnotateResources: true,
+		KubeConfig:        kubeConfig,
+		KubeVersion:       mapOptions.KubeVersion,
+		MapFile:           mapOptions.MapFile,
+		Policy:            mapOptions.Policy,
+		SkipAPIDiscovery:  mapOptions.SkipAPIDiscovery,
+	}
+	options.KubeVersionFromServer = options.KubeVersion == ""
+	kubeVersion, err := common.GetKubernetesVersion(options)
+	if err != nil {
+		return err
+	}
+	options.KubeVersion = kubeVersion
+
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return errors.Wrap(err, "failed to read manifest")
+	}
+	modifiedManifest, findings, err := common.ReplaceManifestUnSupportedAPIs(string(data), options)
+	if err != nil {
+		return err
+	}
+	for _, finding := range findings {
+		if finding.Status == common.StatusApplied {
+			log.Printf("Mapped %s '%s' from '%s' to '%s' for Kubernetes %s.\n", finding.Kind, finding.Name, finding.FromAPI, finding.ToAPI, kubeVersion)
+		}
+	}
+	log.Printf("Applied %d API mapping(s) to the resources of the rendered manifest.\n", countApplied(findings))
+
+	if _, err := io.WriteString(out, modifiedManifest); err != nil {
+		return errors.Wrap(err, "failed to write manifest")
+	}
+	return nil
+}
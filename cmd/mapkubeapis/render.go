@@ -0,0 +1,123 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+// stdinFilename is the --filename which reads the manifest from standard input
+const stdinFilename = "-"
+
+func newRenderCmd(out io.Writer) *cobra.Command {
+	var filename string
+
+	cmd := &cobra.Command{
+		Use:   "render -f FILENAME",
+		Short: "Map deprecated or removed Kubernetes APIs in a manifest stream",
+		Long: "Map the deprecated or removed Kubernetes APIs of the resources in a multi-document manifest, " +
+			"e.g. the output of 'helm template' or 'kustomize build', and write the mapped manifest to standard " +
+			"output. The manifest is read from the file set by --filename, or standard input if it is '-'. " +
+			"A report of the resources found is written to standard error, as a table unless --output is set. " +
+			"No cluster or release is involved, so --kube-version is required.",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mapOptions, _, err := newMapOptions(nil)
+			if err != nil {
+				return err
+			}
+			return Render(os.Stdin, out, cmd.ErrOrStderr(), mapOptions, filename)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&filename, "filename", "f", "", "path to the manifest to map, or '-' to read it from standard input")
+	_ = cmd.MarkFlagRequired("filename")
+
+	return cmd
+}
+
+// Render maps the deprecated or removed APIs of the resources in the manifest of the file, or in if the
+// filename is '-', and writes the mapped manifest to out and the report of the resources found to errOut
+func Render(in io.Reader, out, errOut io.Writer, mapOptions MapOptions, filename string) error {
+	if mapOptions.KubeVersion == "" {
+		return errors.New("the --kube-version flag is required, as the cluster is not accessed")
+	}
+	if mapOptions.Output == "" {
+		mapOptions.Output = outputTable
+	}
+
+	options := common.MapOptions{
+		KubeVersion:      mapOptions.KubeVersion,
+		MapFile:          mapOptions.MapFile,
+		Policy:           mapOptions.Policy,
+		SkipAPIDiscovery: true,
+	}
+	kubeVersion, err := common.GetKubernetesVersion(options)
+	if err != nil {
+		return err
+	}
+	options.KubeVersion = kubeVersion
+
+	var data []byte
+	if filename == stdinFilename {
+		data, err = ioutil.ReadAll(in)
+	} else {
+		data, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read manifest")
+	}
+
+	modifiedManifest, findings, err := common.ReplaceManifestUnSupportedAPIs(string(data), options)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(out, modifiedManifest); err != nil {
+		return errors.Wrap(err, "failed to write manifest")
+	}
+	log.Printf("Applied %d API mapping(s) to the resources of the manifest.\n", countApplied(findings))
+
+	report := &common.Report{
+		KubeVersion: kubeVersion,
+		Releases:    []common.ReleaseReport{{Resources: findings, Diffs: common.DiffManifests(string(data), modifiedManifest)}},
+	}
+	if mapOptions.Output == outputTable {
+		return writeFindingsTable(errOut, findings)
+	}
+	return writeReport(errOut, report, mapOptions.Output)
+}
+
+// countApplied returns the number of findings whose API was mapped
+func countApplied(findings []common.Finding) int {
+	var applied int
+	for _, finding := range findings {
+		if finding.Status == common.StatusApplied {
+			applied++
+		}
+	}
+	return applied
+}
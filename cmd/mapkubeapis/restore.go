@@ -0,0 +1,113 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"log"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hickeyma/helm-mapkubeapis/pkg/common"
+	v2 "github.com/hickeyma/helm-mapkubeapis/pkg/v2"
+	v3 "github.com/hickeyma/helm-mapkubeapis/pkg/v3"
+)
+
+func newRestoreCmd(out io.Writer) *cobra.Command {
+	var backupFile string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "restore [flags] RELEASE",
+		Short: "Restore a release as it was before it was mapped",
+		Long: "Restore the release storage objects from the backup taken before the release was mapped, " +
+			"and delete the release version added by the map. The latest backup of the release in the " +
+			"directory set by --backup-dir is restored, unless --backup-file is set.",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mapOptions := common.MapOptions{
+				BackupDir: settings.BackupDir,
+				DryRun:    settings.DryRun,
+				KubeConfig: common.KubeConfig{
+					Context: settings.KubeContext,
+					File:    settings.KubeConfigFile,
+				},
+				ReleaseName:      args[0],
+				ReleaseNamespace: settings.Namespace,
+				StorageType:      settings.StorageType,
+				TillerOutCluster: settings.TillerOutCluster,
+			}
+			return Restore(mapOptions, settings.RunV2, backupFile, force)
+		},
+	}
+
+	flags := cmd.Flags()
+	settings.AddBackupFlags(flags)
+	flags.StringVar(&backupFile, "backup-file", "", "path to the backup file to restore. The default is the latest backup of the release")
+	flags.BoolVar(&force, "force", false, "restore the backup even if the release has later versions than the backup, which are left as is")
+
+	return cmd
+}
+
+// Restore puts the storage objects of a release back as they were when they were backed up,
+// and deletes the storage objects added after the backup
+func Restore(mapOptions common.MapOptions, runV2 bool, backupFile string, force bool) error {
+	if mapOptions.DryRun {
+		log.Println("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		log.Println("Run without --dry-run to take the actions described below:")
+		log.Println()
+	}
+
+	// default namespace to the Tiller default namespace
+	if runV2 && mapOptions.ReleaseNamespace == "" {
+		mapOptions.ReleaseNamespace = "kube-system"
+	}
+
+	var releaseName = mapOptions.ReleaseName
+	if backupFile == "" {
+		var ref common.StorageRef
+		var err error
+		if runV2 {
+			ref, err = v2.GetStorageRef(mapOptions)
+		} else {
+			ref, err = v3.GetStorageRef(mapOptions)
+		}
+		if err != nil {
+			return err
+		}
+		if backupFile, err = common.LatestBackup(mapOptions.BackupDir, ref); err != nil {
+			return err
+		}
+	}
+
+	backup, err := common.LoadBackup(backupFile)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load backup file: %s", backupFile)
+	}
+	if backup.Release != releaseName {
+		return errors.Errorf("backup file '%s' is of release '%s', not '%s'", backupFile, backup.Release, releaseName)
+	}
+
+	log.Printf("Restore release '%s' from backup: %s\n", releaseName, backupFile)
+	if err := common.RestoreBackup(mapOptions, backup, force); err != nil {
+		return errors.Wrapf(err, "failed to restore release '%s'", releaseName)
+	}
+	log.Printf("Release '%s' restored successfully.\n", releaseName)
+	return nil
+}
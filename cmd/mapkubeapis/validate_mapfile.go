@@ -0,0 +1,64 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hickeyma/helm-mapkubeapis/pkg/mapping"
+)
+
+func newValidateMapfileCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate-mapfile [FILE]",
+		Short: "Check an API mapping file for problems",
+		Long: "Check every mapping of an API mapping file and report all the problems found. " +
+			"The file defaults to the one set by the --mapfile flag.",
+		SilenceUsage: true,
+		Args:         cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mapFile := settings.MapFile
+			if len(args) > 0 {
+				mapFile = args[0]
+			}
+			return ValidateMapfile(out, mapFile)
+		},
+	}
+
+	return cmd
+}
+
+// ValidateMapfile checks an API mapping file and writes the problems found to out.
+// It returns an error if there are any problems.
+func ValidateMapfile(out io.Writer, mapFile string) error {
+	problems, err := mapping.ValidateMapfile(mapFile)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load mapping file: %s", mapFile)
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(out, "%s:%d: %s\n", mapFile, problem.Line, problem.Message)
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("found %d problem(s) in mapping file: %s", len(problems), mapFile)
+	}
+	fmt.Fprintf(out, "Mapping file '%s' is valid.\n", mapFile)
+	return nil
+}
@@ -0,0 +1,350 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	utils "github.com/maorfr/helm-plugin-utils/pkg"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Release storage types
+const (
+	StorageSecrets    = "secrets"
+	StorageConfigMaps = "configmaps"
+)
+
+// backupTimeFormat is the format of the time in backup file names, which sorts in time order
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// StorageRef identifies the storage objects of a release
+type StorageRef struct {
+	// HelmVersion is the Helm version which stores the release, 'v2' or 'v3'
+	HelmVersion string `json:"helmVersion"`
+
+	// Release is the release name
+	Release string `json:"release"`
+
+	// Namespace is the namespace of the storage objects. For Helm v2, this is the Tiller namespace.
+	Namespace string `json:"namespace"`
+
+	// StorageType is the type of the storage objects, 'secrets' or 'configmaps'
+	StorageType string `json:"storageType"`
+}
+
+// Backup is a copy of the storage objects of a release taken before they are updated
+type Backup struct {
+	StorageRef
+
+	// Time is when the backup was taken
+	Time time.Time `json:"time"`
+
+	// Secrets or ConfigMaps are the storage objects as they were before the update
+	Secrets    []v1.Secret    `json:"secrets,omitempty"`
+	ConfigMaps []v1.ConfigMap `json:"configMaps,omitempty"`
+
+	// Created are the names of the storage objects created by the update
+	Created []string `json:"created,omitempty"`
+}
+
+// BackupRelease saves the storage objects of a release which are about to be updated, and the names
+// of the storage objects which are about to be created, in a file of the backup directory.
+// It returns the path of the backup file.
+func BackupRelease(mapOptions MapOptions, ref StorageRef, updated, created []string) (string, error) {
+	if mapOptions.BackupDir == "" {
+		return "", errors.New("backup directory is not set")
+	}
+	clientSet := utils.GetClientSetWithKubeConfig(mapOptions.KubeConfig.File, mapOptions.KubeConfig.Context)
+	if clientSet == nil {
+		return "", errors.Errorf("kubernetes cluster unreachable")
+	}
+	return backupRelease(clientSet, mapOptions.BackupDir, ref, updated, created)
+}
+
+func backupRelease(clientSet kubernetes.Interface, backupDir string, ref StorageRef, updated, created []string) (string, error) {
+	backup := &Backup{StorageRef: ref, Time: time.Now().UTC(), Created: created}
+	for _, name := range updated {
+		switch ref.StorageType {
+		case StorageSecrets:
+			secret, err := clientSet.CoreV1().Secrets(ref.Namespace).Get(name, metav1.GetOptions{})
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to get secret '%s'", name)
+			}
+			backup.Secrets = append(backup.Secrets, *secret)
+		case StorageConfigMaps:
+			configMap, err := clientSet.CoreV1().ConfigMaps(ref.Namespace).Get(name, metav1.GetOptions{})
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to get configmap '%s'", name)
+			}
+			backup.ConfigMaps = append(backup.ConfigMaps, *configMap)
+		default:
+			return "", errors.Errorf("unsupported release storage type: %s", ref.StorageType)
+		}
+	}
+
+	data, err := yaml.Marshal(backup)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode backup")
+	}
+	dir := backupReleaseDir(backupDir, ref)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", errors.Wrapf(err, "failed to create backup directory: %s", dir)
+	}
+	// the storage objects hold the release values, which may be secret
+	file := filepath.Join(dir, backup.Time.Format(backupTimeFormat)+".yaml")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return "", errors.Wrapf(err, "failed to write backup file: %s", file)
+	}
+	return file, nil
+}
+
+// DiscardBackup removes a backup file which is not needed, as the storage objects were not updated.
+// A backup file which cannot be removed is left as is.
+func DiscardBackup(file string) {
+	if file == "" {
+		return
+	}
+	if err := os.Remove(file); err != nil {
+		log.Printf("Failed to remove backup file '%s': %s\n", file, err)
+		return
+	}
+	log.Printf("Removed backup file '%s' as the release was not updated.\n", file)
+}
+
+// LoadBackup loads a backup file
+func LoadBackup(file string) (*Backup, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	backup := new(Backup)
+	if err := yaml.Unmarshal(data, backup); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode backup file: %s", file)
+	}
+	return backup, nil
+}
+
+// LatestBackup returns the path of the latest backup file of a release in the backup directory
+func LatestBackup(backupDir string, ref StorageRef) (string, error) {
+	dir := backupReleaseDir(backupDir, ref)
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", errors.Errorf("no backup found for release '%s' in: %s", ref.Release, dir)
+	}
+	sort.Strings(files)
+	return files[len(files)-1], nil
+}
+
+// RestoreBackup puts the storage objects of the backup back as they were when it was taken, and
+// deletes the storage objects created after it. Unless forced, it fails if the release has
+// revisions which are later than the ones in the backup, as they would be left dangling.
+func RestoreBackup(mapOptions MapOptions, backup *Backup, force bool) error {
+	clientSet := utils.GetClientSetWithKubeConfig(mapOptions.KubeConfig.File, mapOptions.KubeConfig.Context)
+	if clientSet == nil {
+		return errors.Errorf("kubernetes cluster unreachable")
+	}
+	return restoreBackup(clientSet, backup, force, mapOptions.DryRun)
+}
+
+func restoreBackup(clientSet kubernetes.Interface, backup *Backup, force, dryRun bool) error {
+	if !force {
+		if err := checkNoLaterRevisions(clientSet, backup); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range backup.Created {
+		log.Printf("Delete storage object '%s' created after the backup.\n", name)
+		if dryRun {
+			continue
+		}
+		var err error
+		if backup.StorageType == StorageSecrets {
+			err = clientSet.CoreV1().Secrets(backup.Namespace).Delete(name, &metav1.DeleteOptions{})
+		} else {
+			err = clientSet.CoreV1().ConfigMaps(backup.Namespace).Delete(name, &metav1.DeleteOptions{})
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete storage object '%s'", name)
+		}
+	}
+
+	for i := range backup.Secrets {
+		secret := backup.Secrets[i].DeepCopy()
+		log.Printf("Restore storage object '%s'.\n", secret.Name)
+		if dryRun {
+			continue
+		}
+		if err := restoreSecret(clientSet, backup.Namespace, secret); err != nil {
+			return errors.Wrapf(err, "failed to restore secret '%s'", secret.Name)
+		}
+	}
+	for i := range backup.ConfigMaps {
+		configMap := backup.ConfigMaps[i].DeepCopy()
+		log.Printf("Restore storage object '%s'.\n", configMap.Name)
+		if dryRun {
+			continue
+		}
+		if err := restoreConfigMap(clientSet, backup.Namespace, configMap); err != nil {
+			return errors.Wrapf(err, "failed to restore configmap '%s'", configMap.Name)
+		}
+	}
+	return nil
+}
+
+func restoreSecret(clientSet kubernetes.Interface, namespace string, secret *v1.Secret) error {
+	secrets := clientSet.CoreV1().Secrets(namespace)
+	current, err := secrets.Get(secret.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		secret.ObjectMeta = restoredObjectMeta(secret.ObjectMeta, "")
+		_, err = secrets.Create(secret)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	secret.ObjectMeta = restoredObjectMeta(secret.ObjectMeta, current.ResourceVersion)
+	_, err = secrets.Update(secret)
+	return err
+}
+
+func restoreConfigMap(clientSet kubernetes.Interface, namespace string, configMap *v1.ConfigMap) error {
+	configMaps := clientSet.CoreV1().ConfigMaps(namespace)
+	current, err := configMaps.Get(configMap.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		configMap.ObjectMeta = restoredObjectMeta(configMap.ObjectMeta, "")
+		_, err = configMaps.Create(configMap)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	configMap.ObjectMeta = restoredObjectMeta(configMap.ObjectMeta, current.ResourceVersion)
+	_, err = configMaps.Update(configMap)
+	return err
+}
+
+// restoredObjectMeta returns the metadata of a backed up object to write it with, without
+// the fields set by the API server
+func restoredObjectMeta(meta metav1.ObjectMeta, resourceVersion string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:            meta.Name,
+		Namespace:       meta.Namespace,
+		Labels:          meta.Labels,
+		Annotations:     meta.Annotations,
+		OwnerReferences: meta.OwnerReferences,
+		ResourceVersion: resourceVersion,
+	}
+}
+
+// checkNoLaterRevisions returns an error if the release has storage objects of revisions later
+// than the ones in the backup
+func checkNoLaterRevisions(clientSet kubernetes.Interface, backup *Backup) error {
+	nameLabel, versionLabel, selector := storageLabels(backup.StorageRef)
+
+	var objects []metav1.ObjectMeta
+	if backup.StorageType == StorageSecrets {
+		list, err := clientSet.CoreV1().Secrets(backup.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return errors.Wrapf(err, "failed to list the storage objects of release '%s'", backup.Release)
+		}
+		for _, item := range list.Items {
+			objects = append(objects, item.ObjectMeta)
+		}
+	} else {
+		list, err := clientSet.CoreV1().ConfigMaps(backup.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return errors.Wrapf(err, "failed to list the storage objects of release '%s'", backup.Release)
+		}
+		for _, item := range list.Items {
+			objects = append(objects, item.ObjectMeta)
+		}
+	}
+
+	// the storage objects created are of the revisions added after the backup, which may be later
+	// than the revisions in between that were left as is e.g. failed revisions
+	known := make(map[string]bool)
+	var latest int
+	for _, name := range backup.Created {
+		known[name] = true
+		latest = maxRevision(latest, storageObjectRevision(name))
+	}
+	for _, secret := range backup.Secrets {
+		known[secret.Name] = true
+		latest = maxRevision(latest, secret.Labels[versionLabel])
+	}
+	for _, configMap := range backup.ConfigMaps {
+		known[configMap.Name] = true
+		latest = maxRevision(latest, configMap.Labels[versionLabel])
+	}
+
+	for _, object := range objects {
+		if object.Labels[nameLabel] != backup.Release || known[object.Name] {
+			continue
+		}
+		if revision, _ := strconv.Atoi(object.Labels[versionLabel]); revision > latest {
+			return errors.Errorf("release '%s' has revision %d which is later than the backup, "+
+				"use --force to restore the backup anyway", backup.Release, revision)
+		}
+	}
+	return nil
+}
+
+// storageLabels returns the labels of the release name and revision of the storage objects of a
+// release, and the label selector of them
+func storageLabels(ref StorageRef) (string, string, string) {
+	if ref.HelmVersion == "v2" {
+		return "NAME", "VERSION", "OWNER=TILLER,NAME=" + ref.Release
+	}
+	return "name", "version", "owner=helm,name=" + ref.Release
+}
+
+// storageObjectRevision returns the revision in the name of a storage object, which ends with '.v<revision>'
+func storageObjectRevision(name string) string {
+	if i := strings.LastIndex(name, ".v"); i >= 0 {
+		return name[i+2:]
+	}
+	return ""
+}
+
+func maxRevision(revision int, label string) int {
+	if r, err := strconv.Atoi(label); err == nil && r > revision {
+		return r
+	}
+	return revision
+}
+
+// backupReleaseDir returns the directory of the backups of a release
+func backupReleaseDir(backupDir string, ref StorageRef) string {
+	return filepath.Join(backupDir, strings.ToLower(ref.HelmVersion), ref.Namespace, ref.Release)
+}
@@ -0,0 +1,218 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	v3Ref = StorageRef{HelmVersion: "v3", Release: "myrel", Namespace: "default", StorageType: StorageSecrets}
+	v2Ref = StorageRef{HelmVersion: "v2", Release: "myrel", Namespace: "kube-system", StorageType: StorageConfigMaps}
+
+	// the release data is gzipped, so it is not valid UTF-8
+	releaseData = []byte{0x1f, 0x8b, 0x08, 0x00, 0xff, 0xfe, 'r', 'e', 'l', 0x00}
+)
+
+// newReleaseSecret returns the Helm v3 storage object of a release version
+func newReleaseSecret(version int, status string, data []byte) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "sh.helm.release.v1.myrel.v" + strconv.Itoa(version),
+			Namespace: v3Ref.Namespace,
+			Labels:    map[string]string{"owner": "helm", "name": "myrel", "status": status, "version": strconv.Itoa(version)},
+		},
+		Type: "helm.sh/release.v1",
+		Data: map[string][]byte{"release": data},
+	}
+}
+
+// newReleaseConfigMap returns the Helm v2 storage object of a release version
+func newReleaseConfigMap(version int, status string, data string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myrel.v" + strconv.Itoa(version),
+			Namespace: v2Ref.Namespace,
+			Labels:    map[string]string{"OWNER": "TILLER", "NAME": "myrel", "STATUS": status, "VERSION": strconv.Itoa(version)},
+		},
+		Data: map[string]string{"release": data},
+	}
+}
+
+// backupAndLoad backs up the storage objects and loads the backup file written
+func backupAndLoad(t *testing.T, clientSet *fake.Clientset, ref StorageRef, updated, created []string) *Backup {
+	dir, err := ioutil.TempDir("", "mapkubeapis-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file, err := backupRelease(clientSet, dir, ref, updated, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := LatestBackup(dir, ref); err != nil || latest != file {
+		t.Errorf("expected the latest backup to be '%s', got '%s': %v", file, latest, err)
+	}
+	backup, err := LoadBackup(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backup
+}
+
+func TestBackupAndRestoreSecrets(t *testing.T) {
+	orig := newReleaseSecret(1, "deployed", releaseData)
+	clientSet := fake.NewSimpleClientset(orig.DeepCopy())
+	secrets := clientSet.CoreV1().Secrets(v3Ref.Namespace)
+
+	backup := backupAndLoad(t, clientSet, v3Ref, []string{orig.Name}, []string{"sh.helm.release.v1.myrel.v2"})
+
+	// map the release, superseding version 1 and adding version 2
+	if _, err := secrets.Update(newReleaseSecret(1, "superseded", []byte("superseded"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secrets.Create(newReleaseSecret(2, "deployed", []byte("mapped"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreBackup(clientSet, backup, false, false); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := secrets.Get(orig.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.Data, orig.Data) || !reflect.DeepEqual(restored.Labels, orig.Labels) || restored.Type != orig.Type {
+		t.Errorf("expected the secret to be restored as it was backed up, got: %v", restored)
+	}
+	if _, err := secrets.Get("sh.helm.release.v1.myrel.v2", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the secret created after the backup to be deleted, got: %v", err)
+	}
+}
+
+func TestBackupAndRestoreConfigMaps(t *testing.T) {
+	orig := newReleaseConfigMap(1, "DEPLOYED", "H4sIAAAAAAAA/release")
+	clientSet := fake.NewSimpleClientset(orig.DeepCopy())
+	configMaps := clientSet.CoreV1().ConfigMaps(v2Ref.Namespace)
+
+	backup := backupAndLoad(t, clientSet, v2Ref, []string{orig.Name}, []string{"myrel.v2"})
+
+	// map the release, and delete version 1 to check that it is created again
+	if _, err := configMaps.Create(newReleaseConfigMap(2, "DEPLOYED", "mapped")); err != nil {
+		t.Fatal(err)
+	}
+	if err := configMaps.Delete(orig.Name, &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreBackup(clientSet, backup, false, false); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := configMaps.Get(orig.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.Data, orig.Data) || !reflect.DeepEqual(restored.Labels, orig.Labels) {
+		t.Errorf("expected the configmap to be restored as it was backed up, got: %v", restored)
+	}
+	if _, err := configMaps.Get("myrel.v2", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the configmap created after the backup to be deleted, got: %v", err)
+	}
+}
+
+func TestRestoreDryRun(t *testing.T) {
+	orig := newReleaseSecret(1, "deployed", releaseData)
+	clientSet := fake.NewSimpleClientset(orig.DeepCopy())
+	backup := backupAndLoad(t, clientSet, v3Ref, []string{orig.Name}, []string{"sh.helm.release.v1.myrel.v2"})
+
+	mapped := newReleaseSecret(2, "deployed", []byte("mapped"))
+	if _, err := clientSet.CoreV1().Secrets(v3Ref.Namespace).Create(mapped); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreBackup(clientSet, backup, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientSet.CoreV1().Secrets(v3Ref.Namespace).Get(mapped.Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the secret not to be deleted in dry-run mode, got: %v", err)
+	}
+}
+
+func TestRestoreRefusesLaterRevisions(t *testing.T) {
+	orig := newReleaseSecret(1, "deployed", releaseData)
+	clientSet := fake.NewSimpleClientset(orig.DeepCopy(), newReleaseSecret(2, "deployed", []byte("mapped")))
+	backup := backupAndLoad(t, clientSet, v3Ref, []string{orig.Name}, []string{"sh.helm.release.v1.myrel.v2"})
+
+	// revisions of other releases are not taken into account
+	other := newReleaseSecret(7, "deployed", releaseData)
+	other.Name, other.Labels["name"] = "sh.helm.release.v1.other.v7", "other"
+	if _, err := clientSet.CoreV1().Secrets(v3Ref.Namespace).Create(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNoLaterRevisions(clientSet, backup); err != nil {
+		t.Fatalf("expected no later revisions, got: %s", err)
+	}
+
+	// the release is upgraded after it was mapped
+	upgraded := newReleaseSecret(3, "deployed", []byte("upgraded"))
+	if _, err := clientSet.CoreV1().Secrets(v3Ref.Namespace).Create(upgraded); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreBackup(clientSet, backup, false, false); err == nil {
+		t.Fatal("expected an error restoring a backup of a release which has later revisions")
+	}
+	if _, err := clientSet.CoreV1().Secrets(v3Ref.Namespace).Get("sh.helm.release.v1.myrel.v2", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the storage objects to be left as is, got: %v", err)
+	}
+
+	// the later revisions are left as is when forced
+	if err := restoreBackup(clientSet, backup, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientSet.CoreV1().Secrets(v3Ref.Namespace).Get(upgraded.Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the later revision to be left as is, got: %v", err)
+	}
+}
+
+func TestRestoreAfterFailedRevisionsLeftAsIs(t *testing.T) {
+	// version 1 is mapped to version 4, and the failed versions 2 and 3 are left as is
+	orig := newReleaseSecret(1, "deployed", releaseData)
+	clientSet := fake.NewSimpleClientset(orig.DeepCopy(), newReleaseSecret(2, "failed", releaseData), newReleaseSecret(3, "failed", releaseData))
+	backup := backupAndLoad(t, clientSet, v3Ref, []string{orig.Name}, []string{"sh.helm.release.v1.myrel.v4"})
+	if _, err := clientSet.CoreV1().Secrets(v3Ref.Namespace).Create(newReleaseSecret(4, "deployed", []byte("mapped"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNoLaterRevisions(clientSet, backup); err != nil {
+		t.Fatalf("expected no later revisions, got: %s", err)
+	}
+
+	if _, err := clientSet.CoreV1().Secrets(v3Ref.Namespace).Create(newReleaseSecret(5, "deployed", []byte("upgraded"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNoLaterRevisions(clientSet, backup); err == nil {
+		t.Error("expected an error as the release has a revision later than the ones created")
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// catalogAPI is a deprecated Kubernetes API of the deprecation catalog
+type catalogAPI struct {
+	groupVersion string
+
+	// kind is the kind which is deprecated, or empty if all the kinds of the group version are
+	kind string
+
+	deprecatedInVersion string
+	removedInVersion    string
+
+	// replacement is the group version which replaces the API, if any
+	replacement string
+}
+
+// deprecationCatalog is the bundled catalog of the deprecated Kubernetes APIs, from the Kubernetes
+// deprecated API migration guide. It is used to find the resources whose API is deprecated or removed
+// but which no mapping of the mapping file maps, without access to a cluster.
+var deprecationCatalog = []catalogAPI{
+	{"extensions/v1beta1", "DaemonSet", "v1.9", "v1.16", "apps/v1"},
+	{"extensions/v1beta1", "Deployment", "v1.9", "v1.16", "apps/v1"},
+	{"extensions/v1beta1", "ReplicaSet", "v1.9", "v1.16", "apps/v1"},
+	{"extensions/v1beta1", "NetworkPolicy", "v1.9", "v1.16", "networking.k8s.io/v1"},
+	{"extensions/v1beta1", "PodSecurityPolicy", "v1.11", "v1.16", "policy/v1beta1"},
+	{"extensions/v1beta1", "Ingress", "v1.14", "v1.22", "networking.k8s.io/v1"},
+	{"apps/v1beta1", "", "v1.9", "v1.16", "apps/v1"},
+	{"apps/v1beta2", "", "v1.9", "v1.16", "apps/v1"},
+	{"scheduling.k8s.io/v1alpha1", "", "v1.14", "v1.17", "scheduling.k8s.io/v1"},
+	{"admissionregistration.k8s.io/v1beta1", "", "v1.16", "v1.22", "admissionregistration.k8s.io/v1"},
+	{"apiextensions.k8s.io/v1beta1", "", "v1.16", "v1.22", "apiextensions.k8s.io/v1"},
+	{"apiregistration.k8s.io/v1beta1", "", "v1.19", "v1.22", "apiregistration.k8s.io/v1"},
+	{"authentication.k8s.io/v1beta1", "", "v1.19", "v1.22", "authentication.k8s.io/v1"},
+	{"authorization.k8s.io/v1beta1", "", "v1.19", "v1.22", "authorization.k8s.io/v1"},
+	{"certificates.k8s.io/v1beta1", "", "v1.19", "v1.22", "certificates.k8s.io/v1"},
+	{"coordination.k8s.io/v1beta1", "", "v1.19", "v1.22", "coordination.k8s.io/v1"},
+	{"networking.k8s.io/v1beta1", "Ingress", "v1.19", "v1.22", "networking.k8s.io/v1"},
+	{"networking.k8s.io/v1beta1", "IngressClass", "v1.19", "v1.22", "networking.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1alpha1", "", "v1.17", "v1.22", "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "", "v1.17", "v1.22", "rbac.authorization.k8s.io/v1"},
+	{"scheduling.k8s.io/v1beta1", "", "v1.14", "v1.22", "scheduling.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "CSIDriver", "v1.19", "v1.22", "storage.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "CSINode", "v1.17", "v1.22", "storage.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "StorageClass", "v1.19", "v1.22", "storage.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "VolumeAttachment", "v1.19", "v1.22", "storage.k8s.io/v1"},
+	{"batch/v1beta1", "CronJob", "v1.21", "v1.25", "batch/v1"},
+	{"discovery.k8s.io/v1beta1", "EndpointSlice", "v1.21", "v1.25", "discovery.k8s.io/v1"},
+	{"events.k8s.io/v1beta1", "Event", "v1.19", "v1.25", "events.k8s.io/v1"},
+	{"autoscaling/v2beta1", "HorizontalPodAutoscaler", "v1.22", "v1.25", "autoscaling/v2"},
+	{"node.k8s.io/v1beta1", "RuntimeClass", "v1.20", "v1.25", "node.k8s.io/v1"},
+	{"policy/v1beta1", "PodDisruptionBudget", "v1.21", "v1.25", "policy/v1"},
+	{"policy/v1beta1", "PodSecurityPolicy", "v1.21", "v1.25", ""},
+	{"autoscaling/v2beta2", "HorizontalPodAutoscaler", "v1.23", "v1.26", "autoscaling/v2"},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "", "v1.23", "v1.26", "flowcontrol.apiserver.k8s.io/v1beta3"},
+	{"storage.k8s.io/v1beta1", "CSIStorageCapacity", "v1.24", "v1.27", "storage.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "", "v1.26", "v1.29", "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "", "v1.29", "v1.32", "flowcontrol.apiserver.k8s.io/v1"},
+}
+
+// lookupCatalog returns the API of the deprecation catalog for the kind, or nil if it is not deprecated
+func lookupCatalog(gvk schema.GroupVersionKind) *catalogAPI {
+	groupVersion := gvk.GroupVersion().String()
+	for i, api := range deprecationCatalog {
+		if api.groupVersion == groupVersion && (api.kind == "" || api.kind == gvk.Kind) {
+			return &deprecationCatalog[i]
+		}
+	}
+	return nil
+}
+
+// unmappedFindings returns the findings for the resources of the manifest whose API is deprecated or removed
+// in the Kubernetes version according to the deprecation catalog, or is not served by the Kubernetes server
+// if servedAPIs is set, except for the documents covered by a mapping which did not map them. The documents
+// mapped are checked with the API they were mapped to, which may be deprecated or removed too.
+func unmappedFindings(parsed *manifest, covered map[*document]bool, kubeVersion string, servedAPIs *ServedAPIs) ([]Finding, error) {
+	var findings []Finding
+	for _, doc := range parsed.documents {
+		gvk := doc.gvk()
+		mapped, ok := covered[doc]
+		if (ok && !mapped) || gvk.Kind == "" {
+			continue
+		}
+		served := true
+		if servedAPIs != nil {
+			var err error
+			if served, err = servedAPIs.served(gvk); err != nil {
+				return nil, err
+			}
+		}
+		api := lookupCatalog(gvk)
+		if api == nil {
+			api = &catalogAPI{}
+		}
+		deprecated, removed := inVersion(api.deprecatedInVersion, kubeVersion), inVersion(api.removedInVersion, kubeVersion)
+		if served && !deprecated && !removed {
+			continue
+		}
+
+		name, namespace := doc.metadata()
+		finding := Finding{
+			Source:              documentSource(doc.content),
+			Kind:                gvk.Kind,
+			Name:                name,
+			Namespace:           namespace,
+			FromAPI:             gvk.GroupVersion().String(),
+			ToAPI:               api.replacement,
+			DeprecatedInVersion: api.deprecatedInVersion,
+			RemovedInVersion:    api.removedInVersion,
+			Deprecated:          deprecated,
+			// an API which is not served fails as one which is removed
+			Removed: removed || !served,
+			Status:  StatusUnmapped,
+		}
+		subject := "API"
+		if mapped {
+			subject = "API the resource is mapped to"
+		}
+		switch {
+		case !served:
+			finding.Reason = subject + " is not served by the Kubernetes server and no mapping maps it"
+		case removed:
+			finding.Reason = fmt.Sprintf("%s is removed in Kubernetes '%s' and no mapping maps it", subject, api.removedInVersion)
+		default:
+			finding.Reason = fmt.Sprintf("%s is deprecated in Kubernetes '%s' and no mapping maps it", subject, api.deprecatedInVersion)
+		}
+		log.Printf("WARNING: %s '%s' (%s) is not mapped: %s.\n", finding.Kind, finding.Name, finding.FromAPI, finding.Reason)
+		findings = append(findings, finding)
+	}
+	return findings, nil
+}
@@ -17,14 +17,14 @@ limitations under the License.
 package common
 
 import (
-	"gopkg.in/yaml.v2"
+	"fmt"
 	"log"
-	"regexp"
 	"strings"
 
 	utils "github.com/maorfr/helm-plugin-utils/pkg"
 	"github.com/pkg/errors"
 	"golang.org/x/mod/semver"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 
 	"github.com/hickeyma/helm-mapkubeapis/pkg/mapping"
 )
@@ -37,249 +37,211 @@ type KubeConfig struct {
 
 // MapOptions are the options for mapping deprecated APIs in a release
 type MapOptions struct {
-	DryRun           bool
-	KubeConfig       KubeConfig
-	MapFile          string
-	ReleaseName      string
-	ReleaseNamespace string
-	StorageType      string
-	TillerOutCluster bool
+	AnnotateResources bool
+	BackupDir         string
+	Color             bool
+	ConflictRetries   int
+	DryRun            bool
+	FailedRevision    string
+	KubeConfig        KubeConfig
+	KubeVersion       string
+	// KubeVersionFromServer is set when KubeVersion is the version of the Kubernetes server, not set by the user
+	KubeVersionFromServer bool
+	MapFile               string
+	PluginVersion         string
+	Policy                string
+	ReleaseName           string
+	ReleaseNamespace      string
+	SkipAPIDiscovery      bool
+	StorageType           string
+	TillerOutCluster      bool
 }
 
-// Yaml del manifiesto
-type DeploymentYaml struct {
-	APIVersion string `yaml:"apiVersion"`
-	Kind       string `yaml:"kind"`
-	Metadata   struct {
-		Name   string `yaml:"name"`
-		Labels
-	} `yaml:"metadata"`
-	Spec struct {
-		Replicas int `yaml:"replicas"`
-		Selector struct {
-			MatchLabels Labels `yaml:"matchLabels"`
-		} `yaml:"selector"`
-		Template struct {
-			Metadata struct {
-				Labels
-			} `yaml:"metadata"`
-			Spec struct {
-				ImagePullSecrets []struct {
-					Name string `yaml:"name"`
-				} `yaml:"imagePullSecrets"`
-				Containers []struct {
-					Name            string `yaml:"name"`
-					Image           string `yaml:"image"`
-					ImagePullPolicy string `yaml:"imagePullPolicy"`
-					Env             []struct {
-						Name      string      `yaml:"name"`
-						Value     interface{} `yaml:"value,omitempty"`
-						ValueFrom struct {
-							ConfigMapKeyRef struct {
-								Name string `yaml:"name"`
-								Key  string `yaml:"key"`
-							} `yaml:"configMapKeyRef"`
-						} `yaml:"valueFrom,omitempty"`
-					} `yaml:"env"`
-					Ports []struct {
-						Name          string `yaml:"name"`
-						ContainerPort int    `yaml:"containerPort"`
-					} `yaml:"ports"`
-					LivenessProbe struct {
-						HTTPGet struct {
-							Path string `yaml:"path"`
-							Port int    `yaml:"port"`
-						} `yaml:"httpGet"`
-						InitialDelaySeconds int `yaml:"initialDelaySeconds"`
-						TimeoutSeconds      int `yaml:"timeoutSeconds"`
-					} `yaml:"livenessProbe"`
-					ReadinessProbe struct {
-						HTTPGet struct {
-							Path string `yaml:"path"`
-							Port int    `yaml:"port"`
-						} `yaml:"httpGet"`
-						InitialDelaySeconds int `yaml:"initialDelaySeconds"`
-						TimeoutSeconds      int `yaml:"timeoutSeconds"`
-					} `yaml:"readinessProbe"`
-					VolumeMounts []struct {
-						Name      string `yaml:"name"`
-						MountPath string `yaml:"mountPath"`
-						ReadOnly  bool   `yaml:"readOnly"`
-					} `yaml:"volumeMounts"`
-					Resources struct {
-						Requests struct {
-							CPU    string `yaml:"cpu"`
-							Memory string `yaml:"memory"`
-						} `yaml:"requests"`
-					} `yaml:"resources"`
-				} `yaml:"containers"`
-				Volumes []struct {
-					Name   string `yaml:"name"`
-					Secret struct {
-						SecretName string `yaml:"secretName"`
-					} `yaml:"secret,omitempty"`
-					ConfigMap struct {
-						Name string `yaml:"name"`
-					} `yaml:"configMap,omitempty"`
-				} `yaml:"volumes"`
-			} `yaml:"spec"`
-		} `yaml:"template"`
-	} `yaml:"spec"`
-}
+// How a release whose latest revision is failed or pending is mapped
+const (
+	// FailedRevisionRefuse does not map the release
+	FailedRevisionRefuse = "refuse"
+
+	// FailedRevisionDeployed maps the last deployed revision and leaves the later revisions as they are
+	FailedRevisionDeployed = "deployed"
+
+	// FailedRevisionDelete deletes the revisions after the last deployed revision and maps it
+	FailedRevisionDelete = "delete"
+)
 
-type Labels struct {
-	App      string `yaml:"app,omitempty"`
-	Track    string `yaml:"track,omitempty"`
-	Tier     string `yaml:"tier,omitempty"`
-	Chart    string `yaml:"chart,omitempty"`
-	Release  string `yaml:"release,omitempty"`
+// IsValidFailedRevision returns true if the failed revision handling is known
+func IsValidFailedRevision(failedRevision string) bool {
+	return failedRevision == FailedRevisionRefuse || failedRevision == FailedRevisionDeployed || failedRevision == FailedRevisionDelete
 }
 
-type IngressYaml struct {
-	APIVersion string `yaml:"apiVersion"`
-	Kind       string `yaml:"kind"`
-	Metadata   struct {
-		Name   string `yaml:"name"`
-		Labels struct {
-			App      string `yaml:"app"`
-			Chart    string `yaml:"chart"`
-			Release  string `yaml:"release"`
-			Heritage string `yaml:"heritage"`
-			AppKubernetesIoManagedBy string `yaml:"app.kubernetes.io/managed-by,omitempty"`
-		} `yaml:"labels"`
-		Annotations `yaml:"annotations,omitempty"`
-	} `yaml:"metadata"`
-	Spec struct {
-		TLS []struct {
-			Hosts      []string `yaml:"hosts"`
-			SecretName string   `yaml:"secretName"`
-		} `yaml:"tls"`
-		Rules []struct {
-			Host string `yaml:"host"`
-			HTTP struct {
-				Paths []struct {
-					Path    string `yaml:"path"`
-					Backend struct {
-						ServiceName string `yaml:"serviceName"`
-						ServicePort int    `yaml:"servicePort"`
-					} `yaml:"backend"`
-				} `yaml:"paths"`
-			} `yaml:"http"`
-		} `yaml:"rules"`
-	} `yaml:"spec"`
+// RefuseFailedRevision returns the error of not mapping a release whose latest revision is failed or pending
+func RefuseFailedRevision(releaseName string, revision int, status string) error {
+	return errors.Errorf("latest revision %d of release '%s' is '%s'. Mapping it would deploy a revision which was never deployed. "+
+		"Use --failed-revision=%s to map the last deployed revision and leave the later revisions as they are, "+
+		"or --failed-revision=%s to delete the later revisions and map the last deployed revision",
+		revision, releaseName, status, FailedRevisionDeployed, FailedRevisionDelete)
 }
 
-type Annotations struct {
-	KubernetesIoTLSAcme                   string `yaml:"kubernetes.io/tls-acme,omitempty"`
-	KubernetesIoIngressClass              string `yaml:"kubernetes.io/ingress.class,omitempty"`
-	NginxIngressKubernetesIoProxyBodySize string `yaml:"nginx.ingress.kubernetes.io/proxy-body-size,omitempty"`
-	MetaHelmShReleaseName                 string `yaml:"meta.helm.sh/release-name,omitempty"`
+// ReleaseRef identifies a release by its name and the namespace it is stored in.
+// For Helm v2, this is the Tiller namespace.
+type ReleaseRef struct {
+	Name      string
+	Namespace string
 }
 
 // UpgradeDescription is description of why release was upgraded
 const UpgradeDescription = "Kubernetes deprecated API upgrade - DO NOT rollback from this version"
 
 // ReplaceManifestUnSupportedAPIs returns a release manifest with deprecated or removed
-// Kubernetes APIs updated to supported APIs
-func ReplaceManifestUnSupportedAPIs(origManifest, mapFile string, kubeConfig KubeConfig) (string, error) {
-	var modifiedManifest = origManifest
+// Kubernetes APIs updated to supported APIs, and the resources found using those APIs
+func ReplaceManifestUnSupportedAPIs(origManifest string, mapOptions MapOptions) (string, []Finding, error) {
+	var mapFile = mapOptions.MapFile
 	var err error
 	var mapMetadata *mapping.Metadata
 
 	// Load the mapping data
 	if mapMetadata, err = mapping.LoadMapfile(mapFile); err != nil {
-		return "", errors.Wrapf(err, "Failed to load mapping file: %s", mapFile)
+		return "", nil, errors.Wrapf(err, "Failed to load mapping file: %s", mapFile)
+	}
+	if mapOptions.Policy == "" {
+		mapOptions.Policy = mapping.PolicyDeprecated
 	}
 
-	// get the Kubernetes server version
-	kubeVersionStr, err := getKubernetesServerVersion(kubeConfig)
+	// get the Kubernetes version to map for
+	kubeVersionStr, err := GetKubernetesVersion(mapOptions)
 	if err != nil {
-		return "", err
-	}
-	if !semver.IsValid(kubeVersionStr) {
-		return "", errors.Errorf("Failed to get Kubernetes server version")
+		return "", nil, err
 	}
 
 	// Check for deprecated or removed APIs and map accordingly to supported versions
-	for _, mapping := range mapMetadata.Mappings {
-		deprecatedAPI := mapping.DeprecatedAPI
-		supportedAPI := mapping.NewAPI
-		var apiVersionStr string
-		if mapping.DeprecatedInVersion != "" {
-			apiVersionStr = mapping.DeprecatedInVersion
-		} else {
-			apiVersionStr = mapping.RemovedInVersion
+	findings := []Finding{}
+	parsedManifest := parseManifest(origManifest)
+	// covered are the documents matched by a mapping, and whether a mapping mapped them
+	covered := make(map[*document]bool)
+	// found is the index of the finding of each document matched. A later mapping replaces a skipped
+	// finding, and a mapping applied after another is merged into its finding, so that a resource mapped
+	// through a chain of mappings is reported once, from the API it had to the API it has in the end.
+	found := make(map[*document]int)
+	addFinding := func(doc *document, finding Finding, target string) {
+		if i, ok := found[doc]; ok {
+			switch {
+			case findings[i].Status == StatusSkipped:
+				findings[i] = finding
+			case finding.Status == StatusApplied:
+				findings[i].ToAPI = finding.ToAPI
+				findings[i].Rule += " -> " + target
+			}
+			return
 		}
-		if !semver.IsValid(apiVersionStr) {
-			return "", errors.Errorf("Failed to get the deprecated or removed Kubernetes version for API: %s", strings.ReplaceAll(deprecatedAPI, "\n", " "))
+		found[doc] = len(findings)
+		findings = append(findings, finding)
+	}
+	for _, mapping := range mapMetadata.Mappings {
+		deprecatedAPI := mapping.SourceAPI()
+		supportedAPI := mapping.TargetAPI()
+		mappingRule, err := newRule(mapping, mapOptions.Policy)
+		if err != nil {
+			return "", nil, err
 		}
+		apiVersionStr := mappingRule.version
 
-		var modManifestForAPI string
-		var modified = false
-
-		// Replace using regex
-		var re = regexp.MustCompile(deprecatedAPI)
-		modManifestForAPI = re.ReplaceAllString(modifiedManifest, supportedAPI)
-
-		if modManifestForAPI != modifiedManifest {
-			modified = true
-			log.Printf("Found deprecated or removed Kubernetes API:\n\"%s\"\nSupported API equivalent:\n\"%s\"\n", deprecatedAPI, supportedAPI)
-		}
-		if modified {
-			if semver.Compare(apiVersionStr, kubeVersionStr) > 0 {
-				log.Printf("The following API does not require mapping as the "+
-					"API is not deprecated or removed in Kubernetes '%s':\n\"%s\"\n", apiVersionStr,
-					deprecatedAPI)
-			} else {
-				modifiedManifest = modManifestForAPI
+		// Find the documents containing the API
+		var matched []*document
+		for _, doc := range parsedManifest.documents {
+			if mappingRule.matches(doc) {
+				matched = append(matched, doc)
+				covered[doc] = covered[doc]
 			}
 		}
-	}
-
-	// Add labels variables
-	finalManifest := ""
-	parts := strings.Split(modifiedManifest, "---")
+		if len(matched) == 0 {
+			continue
+		}
 
-	// Revision
-	for _, s := range parts {
-		if !strings.Contains(s, "apiVersion") {
+		log.Printf("Found deprecated or removed Kubernetes API:\n\"%s\"\nSupported API equivalent:\n\"%s\"\n", deprecatedAPI, supportedAPI)
+		var skipReason string
+		if apiVersionStr == "" {
+			skipReason = fmt.Sprintf("API has no removed Kubernetes version and the mapping policy is '%s'", mappingRule.policy)
+		} else if semver.Compare(apiVersionStr, kubeVersionStr) > 0 {
+			skipReason = fmt.Sprintf("API is not deprecated or removed in Kubernetes '%s'", apiVersionStr)
+		}
+		if skipReason != "" {
+			log.Printf("The following API does not require mapping as the %s:\n\"%s\"\n", skipReason, deprecatedAPI)
+			for _, doc := range matched {
+				finding := mappingRule.finding(doc, kubeVersionStr)
+				finding.Status = StatusSkipped
+				finding.Reason = skipReason
+				addFinding(doc, finding, describeAPI(supportedAPI))
+			}
 			continue
 		}
-		var deploymentYaml DeploymentYaml
-		err := yaml.Unmarshal([]byte(s), &deploymentYaml)
-		if err != nil {
-			log.Printf("Error parsing YAML file: %s\n", err)
+		for _, doc := range matched {
+			finding := mappingRule.finding(doc, kubeVersionStr)
+			from := doc.gvk()
+			if err := mappingRule.apply(doc); err != nil {
+				return "", nil, errors.Wrapf(err, "Failed to map API: %s", describeAPI(deprecatedAPI))
+			}
+			if mapOptions.AnnotateResources {
+				if err := annotateMappedDocument(doc, from, kubeVersionStr); err != nil {
+					return "", nil, errors.Wrapf(err, "Failed to annotate resource mapped from API: %s", describeAPI(deprecatedAPI))
+				}
+			}
+			finding.Status = StatusApplied
+			addFinding(doc, finding, describeAPI(supportedAPI))
+			covered[doc] = true
 		}
+	}
 
-		if deploymentYaml.Kind == "Deployment" {
-			deploymentYaml.Spec.Selector.MatchLabels = deploymentYaml.Spec.Template.Metadata.Labels
+	// Check for the resources whose API is deprecated, removed or not served, which no mapping maps, including
+	// the APIs the resources are mapped to. The server is only asked which APIs it serves when the APIs are
+	// mapped for its version, as it does not serve the APIs of another version.
+	var servedAPIs *ServedAPIs
+	if mapOptions.KubeVersionFromServer && !mapOptions.SkipAPIDiscovery {
+		if servedAPIs, err = NewServedAPIs(mapOptions.KubeConfig); err != nil {
+			return "", nil, err
+		}
+	}
+	unmapped, err := unmappedFindings(parsedManifest, covered, kubeVersionStr, servedAPIs)
+	if err != nil {
+		return "", nil, err
+	}
+	findings = append(findings, unmapped...)
 
-			yamlString, err := yaml.Marshal(&deploymentYaml)
-			if err != nil {
-				log.Fatalf("error: %v", err)
-			}
-			finalManifest += "---\n" + string(yamlString)
-		} else if deploymentYaml.Kind == "Ingress" {
-			var ingressYaml IngressYaml
-			err := yaml.Unmarshal([]byte(s), &ingressYaml)
-			if err != nil {
-				log.Printf("Error parsing YAML file: %s\n", err)
-			}
+	return parsedManifest.String(), findings, nil
+}
 
-			ingressYaml.Metadata.Labels.AppKubernetesIoManagedBy = "" // "Helm"
-			ingressYaml.Metadata.Annotations.MetaHelmShReleaseName = "" // ingressYaml.Metadata.Labels.Release
+// annotateMappedDocument records on a mapped resource the API it was mapped from and the Kubernetes
+// version it was mapped for. A resource mapped by several mappings keeps the API it was first mapped from.
+func annotateMappedDocument(doc *document, from schema.GroupVersionKind, kubeVersion string) error {
+	if _, ok := doc.annotation(MappedFromAnnotation); !ok {
+		if err := doc.setAnnotation(MappedFromAnnotation, from.String()); err != nil {
+			return err
+		}
+	}
+	return doc.setAnnotation(KubeVersionAnnotation, kubeVersion)
+}
 
-			yamlString, err := yaml.Marshal(&ingressYaml)
-			if err != nil {
-				log.Fatalf("error: %v", err)
-			}
-			finalManifest += "---\n" + string(yamlString)
-		} else {
-			finalManifest += "---" + s
+// GetKubernetesVersion returns the Kubernetes version to map the APIs for. This is the
+// version set in the options if any, otherwise the version of the Kubernetes server.
+func GetKubernetesVersion(mapOptions MapOptions) (string, error) {
+	if mapOptions.KubeVersion != "" {
+		kubeVersion := mapOptions.KubeVersion
+		if !strings.HasPrefix(kubeVersion, "v") {
+			kubeVersion = "v" + kubeVersion
 		}
+		if !semver.IsValid(kubeVersion) {
+			return "", errors.Errorf("Invalid Kubernetes version: %s", mapOptions.KubeVersion)
+		}
+		return kubeVersion, nil
 	}
-	log.Printf("%s\n", finalManifest)
-	return finalManifest, nil
+
+	kubeVersion, err := getKubernetesServerVersion(mapOptions.KubeConfig)
+	if err != nil {
+		return "", err
+	}
+	if !semver.IsValid(kubeVersion) {
+		return "", errors.Errorf("Failed to get Kubernetes server version")
+	}
+	return kubeVersion, nil
 }
 
 func getKubernetesServerVersion(kubeConfig KubeConfig) (string, error) {
@@ -292,4 +254,4 @@ func getKubernetesServerVersion(kubeConfig KubeConfig) (string, error) {
 		return "", errors.Wrap(err, "kubernetes cluster unreachable")
 	}
 	return kubeVersion.GitVersion, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,109 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"testing"
+)
+
+// testMapFile is the mapping file shipped with the plugin
+const testMapFile = "../../config/Map.yaml"
+
+const ingressManifest = `---
+# Source: web/templates/ingress.yaml
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  backend:
+    serviceName: web
+    servicePort: 80
+`
+
+func TestReplaceManifestChainedMappings(t *testing.T) {
+	tests := []struct {
+		kubeVersion string
+		status      string
+		toAPI       string
+		rule        string
+	}{
+		{kubeVersion: "v1.10", status: StatusSkipped, toAPI: "networking.k8s.io/v1beta1"},
+		{kubeVersion: "v1.16", status: StatusApplied, toAPI: "networking.k8s.io/v1beta1",
+			rule: "apiVersion: extensions/v1beta1 kind: Ingress -> apiVersion: networking.k8s.io/v1beta1 kind: Ingress"},
+		{kubeVersion: "v1.22", status: StatusApplied, toAPI: "networking.k8s.io/v1",
+			rule: "apiVersion: extensions/v1beta1 kind: Ingress -> apiVersion: networking.k8s.io/v1beta1 kind: Ingress -> apiVersion: networking.k8s.io/v1 kind: Ingress"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kubeVersion, func(t *testing.T) {
+			mapOptions := MapOptions{KubeVersion: tt.kubeVersion, MapFile: testMapFile, SkipAPIDiscovery: true}
+			modified, findings, err := ReplaceManifestUnSupportedAPIs(ingressManifest, mapOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(findings) != 1 {
+				t.Fatalf("expected the Ingress to be reported once, got %d findings: %+v", len(findings), findings)
+			}
+			finding := findings[0]
+			if finding.Status != tt.status || finding.FromAPI != "extensions/v1beta1" || finding.ToAPI != tt.toAPI {
+				t.Errorf("expected the Ingress to be %s from extensions/v1beta1 to %s, got %s from %s to %s",
+					tt.status, tt.toAPI, finding.Status, finding.FromAPI, finding.ToAPI)
+			}
+			if finding.DeprecatedInVersion != "v1.14" || finding.RemovedInVersion != "v1.22" {
+				t.Errorf("expected the versions of extensions/v1beta1 Ingress, got deprecated in '%s' and removed in '%s'",
+					finding.DeprecatedInVersion, finding.RemovedInVersion)
+			}
+			if tt.rule != "" && finding.Rule != tt.rule {
+				t.Errorf("expected rule '%s', got '%s'", tt.rule, finding.Rule)
+			}
+			if gvk := parseManifest(modified).documents[1].gvk(); tt.status == StatusApplied && gvk.GroupVersion().String() != tt.toAPI {
+				t.Errorf("expected the Ingress to be mapped to %s, got %s", tt.toAPI, gvk.GroupVersion())
+			}
+		})
+	}
+}
+
+func TestReplaceManifestMappedToRemovedAPI(t *testing.T) {
+	manifest := `---
+# Source: web/templates/psp.yaml
+apiVersion: extensions/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: web
+`
+	mapOptions := MapOptions{KubeVersion: "v1.25", MapFile: testMapFile, SkipAPIDiscovery: true}
+	_, findings, err := ReplaceManifestUnSupportedAPIs(manifest, mapOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 2 {
+		t.Fatalf("expected the mapping and the API mapped to to be reported, got %d findings: %+v", len(findings), findings)
+	}
+	if mapped := findings[0]; mapped.Status != StatusApplied || mapped.ToAPI != "policy/v1beta1" {
+		t.Errorf("expected the PodSecurityPolicy to be mapped to policy/v1beta1, got %s to %s", mapped.Status, mapped.ToAPI)
+	}
+	unmapped := findings[1]
+	if unmapped.Status != StatusUnmapped || unmapped.FromAPI != "policy/v1beta1" || !unmapped.Removed || unmapped.RemovedInVersion != "v1.25" {
+		t.Errorf("expected policy/v1beta1 to be reported as removed in v1.25, got: %+v", unmapped)
+	}
+
+	// the API mapped to is not deprecated before
+	mapOptions.KubeVersion = "v1.20"
+	if _, findings, err = ReplaceManifestUnSupportedAPIs(manifest, mapOptions); err != nil || len(findings) != 1 {
+		t.Errorf("expected only the mapping to be reported, got %d findings: %v", len(findings), err)
+	}
+}
@@ -0,0 +1,266 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// ConflictError is returned when the storage objects of a release were changed by another client,
+// e.g. a concurrent helm upgrade, while the release was being mapped
+type ConflictError struct {
+	Release string
+	Err     error
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("release '%s' was changed by another client while it was being mapped: %s", e.Release, e.Err)
+}
+
+// Unwrap returns the error of the write which conflicted
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// IsConflict returns whether the error is, or wraps, a ConflictError
+func IsConflict(err error) bool {
+	var conflictErr *ConflictError
+	return errors.As(err, &conflictErr)
+}
+
+// conflictRetryDelay is the delay before the first retry of a release which conflicted, which is
+// increased for each retry to give the other client time to finish. It is a variable for the tests.
+var conflictRetryDelay = 2 * time.Second
+
+// RetryOnConflict maps a release with mapRelease, and maps it again up to retries times if the
+// release was changed by another client while it was being mapped. Each retry reads the release again.
+func RetryOnConflict(retries int, mapRelease func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := mapRelease()
+		if err == nil || !IsConflict(err) || attempt > retries {
+			return err
+		}
+		log.Printf("%s\n", err)
+		log.Printf("Retry %d of %d: read and map the release again.\n", attempt, retries)
+		time.Sleep(time.Duration(attempt) * conflictRetryDelay)
+	}
+}
+
+// StorageGuard gives compare-and-swap semantics to the writes of the Helm storage drivers. The
+// drivers update the storage objects of a release without their resourceVersion, which overwrites
+// any change made since they were read. The guard remembers the resourceVersion of the storage
+// objects when they are first read, and sets it on their updates and deletes so that the Kubernetes
+// API rejects them for an object which was changed since. It also records when a write conflicted,
+// as the drivers do not return the Kubernetes API errors as they are.
+type StorageGuard struct {
+	mu         sync.Mutex
+	versions   map[string]string
+	conflicted bool
+}
+
+// NewStorageGuard returns a guard which has not read any storage object
+func NewStorageGuard() *StorageGuard {
+	return &StorageGuard{versions: make(map[string]string)}
+}
+
+// Secrets returns the Secrets client guarded by the guard. A nil guard returns the client as is.
+func (g *StorageGuard) Secrets(secrets corev1.SecretInterface) corev1.SecretInterface {
+	if g == nil {
+		return secrets
+	}
+	return &guardedSecrets{SecretInterface: secrets, guard: g}
+}
+
+// ConfigMaps returns the ConfigMaps client guarded by the guard. A nil guard returns the client as is.
+func (g *StorageGuard) ConfigMaps(configMaps corev1.ConfigMapInterface) corev1.ConfigMapInterface {
+	if g == nil {
+		return configMaps
+	}
+	return &guardedConfigMaps{ConfigMapInterface: configMaps, guard: g}
+}
+
+// Conflicted returns whether a write of the guard conflicted with a change of another client
+func (g *StorageGuard) Conflicted() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.conflicted
+}
+
+// read remembers the resourceVersion of an object, unless it was already read or written
+func (g *StorageGuard) read(meta metav1.ObjectMeta) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.versions[meta.Name]; !ok {
+		g.versions[meta.Name] = meta.ResourceVersion
+	}
+}
+
+// beforeUpdate sets the resourceVersion the object had when it was read, if it was
+func (g *StorageGuard) beforeUpdate(meta *metav1.ObjectMeta) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if version, ok := g.versions[meta.Name]; ok && meta.ResourceVersion == "" {
+		meta.ResourceVersion = version
+	}
+}
+
+// beforeDelete returns the delete options with the precondition that the object has the resourceVersion
+// it had when it was read, if it was
+func (g *StorageGuard) beforeDelete(name string, options *metav1.DeleteOptions) *metav1.DeleteOptions {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	version, ok := g.versions[name]
+	if !ok {
+		return options
+	}
+	guarded := &metav1.DeleteOptions{}
+	if options != nil {
+		guarded = options.DeepCopy()
+	}
+	if guarded.Preconditions == nil {
+		guarded.Preconditions = &metav1.Preconditions{ResourceVersion: &version}
+	}
+	return guarded
+}
+
+// deleted forgets the resourceVersion of an object after it is deleted, or records if the delete conflicted
+func (g *StorageGuard) deleted(name string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case err == nil:
+		delete(g.versions, name)
+	case apierrors.IsConflict(err):
+		g.conflicted = true
+	}
+}
+
+// written remembers the resourceVersion of an object after a write, or records if the write conflicted
+func (g *StorageGuard) written(meta metav1.ObjectMeta, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case err == nil:
+		g.versions[meta.Name] = meta.ResourceVersion
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		g.conflicted = true
+	}
+}
+
+type guardedSecrets struct {
+	corev1.SecretInterface
+	guard *StorageGuard
+}
+
+func (s *guardedSecrets) Get(name string, options metav1.GetOptions) (*v1.Secret, error) {
+	secret, err := s.SecretInterface.Get(name, options)
+	if err == nil {
+		s.guard.read(secret.ObjectMeta)
+	}
+	return secret, err
+}
+
+func (s *guardedSecrets) List(opts metav1.ListOptions) (*v1.SecretList, error) {
+	list, err := s.SecretInterface.List(opts)
+	if err == nil {
+		for _, item := range list.Items {
+			s.guard.read(item.ObjectMeta)
+		}
+	}
+	return list, err
+}
+
+func (s *guardedSecrets) Create(secret *v1.Secret) (*v1.Secret, error) {
+	created, err := s.SecretInterface.Create(secret)
+	s.guard.written(writtenMeta(created, err), err)
+	return created, err
+}
+
+func (s *guardedSecrets) Update(secret *v1.Secret) (*v1.Secret, error) {
+	s.guard.beforeUpdate(&secret.ObjectMeta)
+	updated, err := s.SecretInterface.Update(secret)
+	s.guard.written(writtenMeta(updated, err), err)
+	return updated, err
+}
+
+func (s *guardedSecrets) Delete(name string, options *metav1.DeleteOptions) error {
+	err := s.SecretInterface.Delete(name, s.guard.beforeDelete(name, options))
+	s.guard.deleted(name, err)
+	return err
+}
+
+type guardedConfigMaps struct {
+	corev1.ConfigMapInterface
+	guard *StorageGuard
+}
+
+func (c *guardedConfigMaps) Get(name string, options metav1.GetOptions) (*v1.ConfigMap, error) {
+	configMap, err := c.ConfigMapInterface.Get(name, options)
+	if err == nil {
+		c.guard.read(configMap.ObjectMeta)
+	}
+	return configMap, err
+}
+
+func (c *guardedConfigMaps) List(opts metav1.ListOptions) (*v1.ConfigMapList, error) {
+	list, err := c.ConfigMapInterface.List(opts)
+	if err == nil {
+		for _, item := range list.Items {
+			c.guard.read(item.ObjectMeta)
+		}
+	}
+	return list, err
+}
+
+func (c *guardedConfigMaps) Create(configMap *v1.ConfigMap) (*v1.ConfigMap, error) {
+	created, err := c.ConfigMapInterface.Create(configMap)
+	c.guard.written(writtenMeta(created, err), err)
+	return created, err
+}
+
+func (c *guardedConfigMaps) Update(configMap *v1.ConfigMap) (*v1.ConfigMap, error) {
+	c.guard.beforeUpdate(&configMap.ObjectMeta)
+	updated, err := c.ConfigMapInterface.Update(configMap)
+	c.guard.written(writtenMeta(updated, err), err)
+	return updated, err
+}
+
+func (c *guardedConfigMaps) Delete(name string, options *metav1.DeleteOptions) error {
+	err := c.ConfigMapInterface.Delete(name, c.guard.beforeDelete(name, options))
+	c.guard.deleted(name, err)
+	return err
+}
+
+// writtenMeta returns the metadata of an object returned by a write, if the write succeeded
+func writtenMeta(obj metav1.Object, err error) metav1.ObjectMeta {
+	if err != nil {
+		return metav1.ObjectMeta{}
+	}
+	return metav1.ObjectMeta{Name: obj.GetName(), ResourceVersion: obj.GetResourceVersion()}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newVersionedClientset returns a fake clientset which sets the resourceVersion of the Secrets it
+// updates, and rejects an update of a Secret whose resourceVersion is set and is not the current one,
+// as the Kubernetes API does
+func newVersionedClientset(secrets ...*v1.Secret) *fake.Clientset {
+	clientSet := fake.NewSimpleClientset()
+	for _, secret := range secrets {
+		secret = secret.DeepCopy()
+		secret.ResourceVersion = "1"
+		clientSet.Tracker().Add(secret)
+	}
+
+	version := 1
+	clientSet.PrependReactor("update", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		secret := action.(k8stesting.UpdateAction).GetObject().(*v1.Secret).DeepCopy()
+		obj, err := clientSet.Tracker().Get(action.GetResource(), secret.Namespace, secret.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		if current := obj.(*v1.Secret); secret.ResourceVersion != "" && secret.ResourceVersion != current.ResourceVersion {
+			return true, nil, apierrors.NewConflict(action.GetResource().GroupResource(), secret.Name, errors.New("the object has been modified"))
+		}
+		version++
+		secret.ResourceVersion = strconv.Itoa(version)
+		return true, secret, clientSet.Tracker().Update(action.GetResource(), secret, secret.Namespace)
+	})
+	return clientSet
+}
+
+// writeRelease reads the storage object of a release version through a guard, calls change if it is set,
+// and writes the storage object mapped without its resourceVersion, as the Helm storage drivers do.
+// It returns the data read.
+func writeRelease(guard *StorageGuard, clientSet *fake.Clientset, change func()) (string, error) {
+	secrets := guard.Secrets(clientSet.CoreV1().Secrets(v3Ref.Namespace))
+	secret, err := secrets.Get("sh.helm.release.v1.myrel.v1", metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if change != nil {
+		change()
+	}
+	_, err = secrets.Update(newReleaseSecret(1, "superseded", []byte("mapped")))
+	return string(secret.Data["release"]), err
+}
+
+// changeRelease updates the storage object of a release version as another client does
+func changeRelease(t *testing.T, clientSet *fake.Clientset, data string) {
+	if _, err := clientSet.CoreV1().Secrets(v3Ref.Namespace).Update(newReleaseSecret(1, "deployed", []byte(data))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageGuardRejectsStaleUpdate(t *testing.T) {
+	clientSet := newVersionedClientset(newReleaseSecret(1, "deployed", releaseData))
+
+	guard := NewStorageGuard()
+	if _, err := writeRelease(guard, clientSet, nil); err != nil {
+		t.Fatal(err)
+	}
+	if guard.Conflicted() {
+		t.Error("expected no conflict when the release was not changed since it was read")
+	}
+
+	// a second write through the guard has the resourceVersion of the first one
+	if _, err := guard.Secrets(clientSet.CoreV1().Secrets(v3Ref.Namespace)).Update(newReleaseSecret(1, "superseded", []byte("again"))); err != nil {
+		t.Fatalf("expected the guard to track its own writes, got: %s", err)
+	}
+
+	guard = NewStorageGuard()
+	_, err := writeRelease(guard, clientSet, func() { changeRelease(t, clientSet, "upgraded") })
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("expected a conflict updating a release changed since it was read, got: %v", err)
+	}
+	if !guard.Conflicted() {
+		t.Error("expected the guard to record the conflict")
+	}
+	secret, err := clientSet.CoreV1().Secrets(v3Ref.Namespace).Get("sh.helm.release.v1.myrel.v1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := string(secret.Data["release"]); data != "upgraded" {
+		t.Errorf("expected the change of the other client to be kept, got '%s'", data)
+	}
+}
+
+func TestRetryOnConflictReadsReleaseAgain(t *testing.T) {
+	defer func(delay time.Duration) { conflictRetryDelay = delay }(conflictRetryDelay)
+	conflictRetryDelay = 0
+
+	clientSet := newVersionedClientset(newReleaseSecret(1, "deployed", []byte("installed")))
+	var reads []string
+	err := RetryOnConflict(2, func() error {
+		guard := NewStorageGuard()
+		var change func()
+		if len(reads) == 0 {
+			change = func() { changeRelease(t, clientSet, "upgraded") }
+		}
+		read, err := writeRelease(guard, clientSet, change)
+		reads = append(reads, read)
+		if err != nil && guard.Conflicted() {
+			err = &ConflictError{Release: "myrel", Err: err}
+		}
+		return errors.Wrap(err, "failed to update release 'myrel'")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reads) != 2 || reads[0] != "installed" || reads[1] != "upgraded" {
+		t.Errorf("expected the release to be read again after the conflict, got: %v", reads)
+	}
+}
+
+func TestRetryOnConflictGivesUp(t *testing.T) {
+	defer func(delay time.Duration) { conflictRetryDelay = delay }(conflictRetryDelay)
+	conflictRetryDelay = 0
+
+	attempts := 0
+	err := RetryOnConflict(2, func() error {
+		attempts++
+		return errors.Wrap(&ConflictError{Release: "myrel", Err: errors.New("conflict")}, "failed to update release 'myrel'")
+	})
+	if !IsConflict(err) || attempts != 3 {
+		t.Errorf("expected the conflict after 3 attempts, got %d attempts: %v", attempts, err)
+	}
+}
+
+func TestRetryOnConflictDoesNotRetryOtherErrors(t *testing.T) {
+	defer func(delay time.Duration) { conflictRetryDelay = delay }(conflictRetryDelay)
+	conflictRetryDelay = 0
+
+	clientSet := newVersionedClientset(newReleaseSecret(1, "deployed", releaseData))
+	updateErr := errors.New("update failed")
+	clientSet.PrependReactor("update", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, updateErr
+	})
+
+	attempts := 0
+	err := RetryOnConflict(2, func() error {
+		attempts++
+		guard := NewStorageGuard()
+		_, err := writeRelease(guard, clientSet, nil)
+		if guard.Conflicted() {
+			t.Error("expected the guard not to record a conflict for another error")
+		}
+		return err
+	})
+	if errors.Cause(err) != updateErr || attempts != 1 {
+		t.Errorf("expected the error of the update after 1 attempt, got %d attempts: %v", attempts, err)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"log"
+	"regexp"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Register the built-in Transformers
+func init() {
+	deploymentV1 := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	for _, from := range []schema.GroupVersionKind{
+		{Group: "extensions", Version: "v1beta1", Kind: "Deployment"},
+		{Group: "apps", Version: "v1beta1", Kind: "Deployment"},
+		{Group: "apps", Version: "v1beta2", Kind: "Deployment"},
+	} {
+		mustRegisterTransformer(from, deploymentV1, TransformerFunc(defaultDeploymentSelector))
+	}
+
+	ingressV1 := schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+	for _, from := range []schema.GroupVersionKind{
+		{Group: "extensions", Version: "v1beta1", Kind: "Ingress"},
+		{Group: "networking.k8s.io", Version: "v1beta1", Kind: "Ingress"},
+	} {
+		mustRegisterTransformer(from, ingressV1, TransformerFunc(convertIngressToV1))
+	}
+}
+
+// convertDocument runs the Transformer registered for a document which has been
+// mapped from the given API to the API it now has, if there is one.
+func convertDocument(content string, from schema.GroupVersionKind) (string, error) {
+	to := documentGVK(content)
+	if to == from {
+		return content, nil
+	}
+	transformer := GetTransformer(from, to)
+	if transformer == nil {
+		return content, nil
+	}
+
+	e, err := newEditDocument(content)
+	if err != nil || e == nil {
+		return content, err
+	}
+	converted, err := transformer.Transform(e.root)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to convert %s to %s", from, to)
+	}
+	if !converted {
+		return content, nil
+	}
+	return e.String()
+}
+
+// defaultDeploymentSelector sets the selector of a Deployment to its pod
+// template labels when it is unset. The selector was defaulted this way
+// before apps/v1, where it became a required field.
+func defaultDeploymentSelector(deployment *yaml.Node) (bool, error) {
+	spec := mapValue(deployment, "spec")
+	labels := lookup(spec, "template", "metadata", "labels")
+	if spec == nil || labels == nil || labels.Kind != yaml.MappingNode {
+		return false, nil
+	}
+
+	selector := mapValue(spec, "selector")
+	if selector != nil && (mapValue(selector, "matchLabels") != nil || mapValue(selector, "matchExpressions") != nil) {
+		return false, nil
+	}
+	switch {
+	case selector == nil:
+		selector = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		insertMapValue(spec, "selector", selector, "template")
+	case selector.Kind != yaml.MappingNode:
+		*selector = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	insertMapValue(selector, "matchLabels", copyNode(labels), "")
+
+	log.Printf("Set selector of Deployment '%s' to its pod template labels as required by apps/v1.\n",
+		scalarValue(lookup(deployment, "metadata", "name")))
+	return true, nil
+}
+
+// ingressClassAnnotation is the annotation replaced by spec.ingressClassName in networking.k8s.io/v1
+const ingressClassAnnotation = "kubernetes.io/ingress.class"
+
+var numericPort = regexp.MustCompile(`^[0-9]+$`)
+
+// convertIngressToV1 converts an Ingress from the v1beta1 schema to the
+// networking.k8s.io/v1 schema. Backends are converted to service backends,
+// paths get the required pathType, the default backend is moved to
+// spec.defaultBackend and the ingress class annotation to spec.ingressClassName.
+// TLS is the same in both schemas and is kept as is.
+func convertIngressToV1(ingress *yaml.Node) (bool, error) {
+	spec := mapValue(ingress, "spec")
+	if spec == nil || spec.Kind != yaml.MappingNode {
+		return false, nil
+	}
+
+	var converted bool
+	if backend := mapValue(spec, "backend"); backend != nil {
+		renameMapKey(spec, "backend", "defaultBackend")
+		convertIngressBackend(backend)
+		converted = true
+	}
+	for _, rule := range sequenceItems(mapValue(spec, "rules")) {
+		for _, path := range sequenceItems(lookup(rule, "http", "paths")) {
+			if convertIngressBackend(mapValue(path, "backend")) {
+				converted = true
+			}
+			if path.Kind == yaml.MappingNode && mapValue(path, "pathType") == nil {
+				insertMapValue(path, "pathType", newScalar("ImplementationSpecific", "!!str"), "backend")
+				converted = true
+			}
+		}
+	}
+
+	annotations := lookup(ingress, "metadata", "annotations")
+	if class := deleteMapValue(annotations, ingressClassAnnotation); class != nil {
+		if mapValue(spec, "ingressClassName") == nil && scalarValue(class) != "" {
+			insertMapValue(spec, "ingressClassName", newScalar(class.Value, "!!str"), firstKey(spec))
+		}
+		if len(annotations.Content) == 0 {
+			deleteMapValue(mapValue(ingress, "metadata"), "annotations")
+		}
+		converted = true
+	}
+
+	if converted {
+		log.Printf("Converted Ingress '%s' to the networking.k8s.io/v1 schema.\n",
+			scalarValue(lookup(ingress, "metadata", "name")))
+	}
+	return converted, nil
+}
+
+// convertIngressBackend converts a serviceName/servicePort backend to a
+// service backend with a port number or name
+func convertIngressBackend(backend *yaml.Node) bool {
+	serviceName := deleteMapValue(backend, "serviceName")
+	servicePort := deleteMapValue(backend, "servicePort")
+	if serviceName == nil && servicePort == nil {
+		return false
+	}
+
+	service := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if serviceName != nil {
+		insertMapValue(service, "name", serviceName, "")
+	}
+	if servicePort != nil {
+		port := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		if servicePort.Tag == "!!int" || numericPort.MatchString(servicePort.Value) {
+			insertMapValue(port, "number", newScalar(servicePort.Value, "!!int"), "")
+		} else {
+			insertMapValue(port, "name", servicePort, "")
+		}
+		insertMapValue(service, "port", port, "")
+	}
+
+	insertMapValue(backend, "service", service, firstKey(backend))
+	return true
+}
@@ -0,0 +1,291 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var extensionsIngress = schema.GroupVersionKind{Group: "extensions", Version: "v1beta1", Kind: "Ingress"}
+
+func TestConvertIngressToV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name: "service port number",
+			content: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  rules:
+  - http:
+      paths:
+      - path: /
+        backend:
+          serviceName: web
+          servicePort: 80
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  rules:
+  - http:
+      paths:
+      - path: /
+        pathType: ImplementationSpecific
+        backend:
+          service:
+            name: web
+            port:
+              number: 80
+`,
+		},
+		{
+			name: "quoted service port number",
+			content: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  rules:
+  - http:
+      paths:
+      - backend:
+          serviceName: web
+          servicePort: "80"
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  rules:
+  - http:
+      paths:
+      - pathType: ImplementationSpecific
+        backend:
+          service:
+            name: web
+            port:
+              number: 80
+`,
+		},
+		{
+			name: "service port name and path type",
+			content: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  rules:
+  - host: web.example.com
+    http:
+      paths:
+      - path: /api
+        pathType: Prefix   # set by the chart
+        backend:
+          serviceName: api
+          servicePort: http
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  rules:
+  - host: web.example.com
+    http:
+      paths:
+      - path: /api
+        pathType: Prefix   # set by the chart
+        backend:
+          service:
+            name: api
+            port:
+              name: http
+`,
+		},
+		{
+			name: "ingress class name and annotation",
+			content: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+  annotations:
+    kubernetes.io/ingress.class: nginx
+    nginx.ingress.kubernetes.io/rewrite-target: /
+spec:
+  ingressClassName: internal
+  rules:
+  - host: web.example.com
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+  annotations:
+    nginx.ingress.kubernetes.io/rewrite-target: /
+spec:
+  ingressClassName: internal
+  rules:
+  - host: web.example.com
+`,
+		},
+		{
+			name: "ingress class annotation removed",
+			content: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+  annotations:
+    kubernetes.io/ingress.class: nginx
+  labels:
+    app: web
+spec:
+  rules:
+  - host: web.example.com
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  ingressClassName: nginx
+  rules:
+  - host: web.example.com
+`,
+		},
+		{
+			name: "default backend and TLS",
+			content: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  backend:
+    serviceName: default
+    servicePort: 8080
+  tls:
+  - hosts:
+    - web.example.com
+    secretName: web-tls   # managed by cert-manager
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  defaultBackend:
+    service:
+      name: default
+      port:
+        number: 8080
+  tls:
+  - hosts:
+    - web.example.com
+    secretName: web-tls   # managed by cert-manager
+`,
+		},
+		{
+			name: "already converted",
+			content: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  rules:
+  - http:
+      paths:
+      - path: /
+        pathType:   Exact
+        backend:
+          service:
+            name: web
+            port: {number: 80}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == "" {
+				want = tt.content
+			}
+			got, err := convertDocument(tt.content, extensionsIngress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestConvertDeploymentSelector(t *testing.T) {
+	content := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+`
+	want := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+`
+	got, err := convertDocument(content, extensionsDeployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	// a selector which is set is left as is
+	if got, err := convertDocument(want, extensionsDeployment); err != nil || got != want {
+		t.Errorf("expected the selector to be left as is, got:\n%s", got)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// diffContext is the number of unchanged lines shown around the changes of a diff
+const diffContext = 3
+
+// ANSI colors of the lines of a diff
+const (
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+)
+
+// unknownSource is the source of the resources whose template is not known
+const unknownSource = "(unknown)"
+
+// sourcePattern matches the comment Helm adds before each resource of a manifest with the template it is from
+var sourcePattern = regexp.MustCompile(`(?m)^# Source: (.+?)\s*$`)
+
+// ResourceDiff is the unified diff of a resource of a manifest whose APIs were mapped
+type ResourceDiff struct {
+	// Source is the path of the template the resource is from
+	Source    string `json:"source,omitempty"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Diff      string `json:"diff"`
+}
+
+// DiffManifests returns the unified diffs of the resources which differ between the original
+// manifest and the manifest with the mapped APIs
+func DiffManifests(origManifest, modifiedManifest string) []ResourceDiff {
+	origDocs := parseManifest(origManifest).documents
+	modifiedDocs := parseManifest(modifiedManifest).documents
+	if len(origDocs) != len(modifiedDocs) {
+		// the documents are mapped in place, so this is not expected
+		return []ResourceDiff{{Diff: unifiedDiff("a", "b", origManifest, modifiedManifest)}}
+	}
+
+	var diffs []ResourceDiff
+	for i, orig := range origDocs {
+		modified := modifiedDocs[i]
+		if orig.content == modified.content {
+			continue
+		}
+		from, to := orig.gvk(), modified.gvk()
+		name, namespace := orig.metadata()
+		resource := from.Kind + "/" + name
+		diffs = append(diffs, ResourceDiff{
+			Source:    documentSource(orig.content),
+			Kind:      from.Kind,
+			Name:      name,
+			Namespace: namespace,
+			Diff: unifiedDiff(fmt.Sprintf("%s (%s)", resource, from.GroupVersion()),
+				fmt.Sprintf("%s (%s)", resource, to.GroupVersion()), orig.content, modified.content),
+		})
+	}
+	return diffs
+}
+
+// FormatDiffs returns the diffs of the resources grouped by the template they are from,
+// in the order the templates are first found. The lines are colored if color is set.
+func FormatDiffs(diffs []ResourceDiff, color bool) string {
+	var sources []string
+	grouped := make(map[string][]ResourceDiff)
+	for _, diff := range diffs {
+		source := diff.Source
+		if source == "" {
+			source = unknownSource
+		}
+		if _, ok := grouped[source]; !ok {
+			sources = append(sources, source)
+		}
+		grouped[source] = append(grouped[source], diff)
+	}
+
+	var sb strings.Builder
+	for _, source := range sources {
+		sb.WriteString(colorLine("# Source: "+source, colorBold, color))
+		for _, diff := range grouped[source] {
+			for _, line := range strings.SplitAfter(diff.Diff, "\n") {
+				if line == "" {
+					continue
+				}
+				switch {
+				case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+					sb.WriteString(colorLine(line, colorBold, color))
+				case strings.HasPrefix(line, "@@"):
+					sb.WriteString(colorLine(line, colorCyan, color))
+				case strings.HasPrefix(line, "-"):
+					sb.WriteString(colorLine(line, colorRed, color))
+				case strings.HasPrefix(line, "+"):
+					sb.WriteString(colorLine(line, colorGreen, color))
+				default:
+					sb.WriteString(line)
+				}
+			}
+		}
+	}
+	return sb.String()
+}
+
+func colorLine(line, code string, color bool) string {
+	line = strings.TrimSuffix(line, "\n")
+	if color {
+		line = code + line + colorReset
+	}
+	return line + "\n"
+}
+
+// documentSource returns the template path of the Source comment of a document, if any
+func documentSource(content string) string {
+	if match := sourcePattern.FindStringSubmatch(content); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// unifiedDiff returns the unified diff of two texts by line, or "" if they are the same
+func unifiedDiff(fromName, toName, a, b string) string {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(a),
+		B:        diffLines(b),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  diffContext,
+	})
+	if err != nil {
+		// the diff is written to a string, so this is not expected
+		return ""
+	}
+	return diff
+}
+
+// diffLines returns the lines of a text, each ending with a newline as difflib expects
+func diffLines(s string) []string {
+	lines := splitLines(s)
+	for i := range lines {
+		lines[i] += "\n"
+	}
+	return lines
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
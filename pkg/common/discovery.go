@@ -0,0 +1,136 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	utils "github.com/maorfr/helm-plugin-utils/pkg"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// ServedAPIs checks with the discovery API of the Kubernetes server that it serves the APIs of the
+// resources of manifests. The Kubernetes version is not enough, as aggregated APIs and CRDs may not be
+// installed, and distributions may disable APIs.
+type ServedAPIs struct {
+	discovery discovery.DiscoveryInterface
+
+	// kinds are the kinds served for each group version which was looked up, nil if it is not served
+	kinds map[schema.GroupVersion]map[string]bool
+}
+
+// NewServedAPIs returns a ServedAPIs for the Kubernetes server of the kubeconfig
+func NewServedAPIs(kubeConfig KubeConfig) (*ServedAPIs, error) {
+	clientSet := utils.GetClientSetWithKubeConfig(kubeConfig.File, kubeConfig.Context)
+	if clientSet == nil {
+		return nil, errors.Errorf("kubernetes cluster unreachable")
+	}
+	return newServedAPIs(clientSet.Discovery()), nil
+}
+
+func newServedAPIs(client discovery.DiscoveryInterface) *ServedAPIs {
+	return &ServedAPIs{discovery: client, kinds: make(map[schema.GroupVersion]map[string]bool)}
+}
+
+// VerifyServedAPIs returns an error if the Kubernetes server does not serve the API of any resource
+// of the manifests, unless the check is skipped in the options. The check is also skipped when the APIs
+// are mapped for another Kubernetes version than the version of the server, e.g. ahead of an upgrade.
+func VerifyServedAPIs(mapOptions MapOptions, manifests ...string) error {
+	if mapOptions.SkipAPIDiscovery {
+		return nil
+	}
+	if !mapOptions.KubeVersionFromServer {
+		serverVersion, err := getKubernetesServerVersion(mapOptions.KubeConfig)
+		if err != nil {
+			return err
+		}
+		if semver.IsValid(serverVersion) && semver.MajorMinor(serverVersion) != semver.MajorMinor(mapOptions.KubeVersion) {
+			log.Printf("Skip checking that the Kubernetes server serves the APIs of the mapped manifest, as the APIs are mapped for Kubernetes %s and the server is %s.\n",
+				mapOptions.KubeVersion, serverVersion)
+			return nil
+		}
+	}
+	servedAPIs, err := NewServedAPIs(mapOptions.KubeConfig)
+	if err != nil {
+		return err
+	}
+	for _, manifest := range manifests {
+		if err := servedAPIs.Verify(manifest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify returns an error listing the resources of the manifest whose API the Kubernetes server does not serve
+func (s *ServedAPIs) Verify(manifest string) error {
+	log.Println("Check that the Kubernetes server serves the APIs of the mapped manifest.")
+	var unserved []string
+	for _, doc := range parseManifest(manifest).documents {
+		gvk := doc.gvk()
+		if gvk.Kind == "" {
+			continue
+		}
+		served, err := s.served(gvk)
+		if err != nil {
+			return err
+		}
+		if !served {
+			name, _ := doc.metadata()
+			unserved = append(unserved, fmt.Sprintf("%s/%s (%s)", gvk.Kind, name, gvk.GroupVersion()))
+		}
+	}
+	if len(unserved) > 0 {
+		return errors.Errorf("the Kubernetes server does not serve the APIs of resources in the mapped manifest: %s. Set --skip-api-discovery to skip this check", strings.Join(unserved, ", "))
+	}
+	return nil
+}
+
+// served returns whether the Kubernetes server serves the kind in the group version
+func (s *ServedAPIs) served(gvk schema.GroupVersionKind) (bool, error) {
+	gv := gvk.GroupVersion()
+	kinds, ok := s.kinds[gv]
+	if !ok {
+		resources, err := s.discovery.ServerResourcesForGroupVersion(gv.String())
+		switch {
+		case apierrors.IsNotFound(err):
+			// the group version is not served
+		case err != nil:
+			return false, errors.Wrapf(err, "failed to discover the resources of API %s", gv)
+		default:
+			kinds = make(map[string]bool)
+			for _, resource := range resources.APIResources {
+				// subresources have the kind of the resource they return e.g. Scale
+				if !strings.Contains(resource.Name, "/") {
+					kinds[resource.Kind] = true
+				}
+			}
+		}
+		s.kinds[gv] = kinds
+	}
+	// list kinds are not resources, and are served with the group version
+	if strings.HasSuffix(gvk.Kind, "List") {
+		return kinds != nil, nil
+	}
+	return kinds[gvk.Kind], nil
+}
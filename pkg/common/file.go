@@ -0,0 +1,272 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// v3StorageObjectPrefix is the prefix of the names of the Helm v3 release storage objects
+const v3StorageObjectPrefix = "sh.helm.release.v1."
+
+// magicGzip is the header of gzip compressed data
+var magicGzip = []byte{0x1f, 0x8b, 0x08}
+
+// StorageObject is a release storage object, a Secret or a ConfigMap, read from a file
+type StorageObject struct {
+	Secret    *v1.Secret
+	ConfigMap *v1.ConfigMap
+}
+
+// ReadStorageObjects reads the release storage objects of a YAML or JSON file, as dumped by kubectl.
+// The file can have many documents, and lists of objects.
+func ReadStorageObjects(file string) ([]StorageObject, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []StorageObject
+	decoder := yamlv3.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yamlv3.Node
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode file: %s", file)
+		}
+		docYAML, err := yamlv3.Marshal(&doc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode file: %s", file)
+		}
+		docJSON, err := yaml.YAMLToJSON(docYAML)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode file: %s", file)
+		}
+		if string(docJSON) == "null" {
+			continue
+		}
+		docObjects, err := decodeStorageObjects(docJSON, "")
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode file: %s", file)
+		}
+		objects = append(objects, docObjects...)
+	}
+	if len(objects) == 0 {
+		return nil, errors.Errorf("no release storage object found in file: %s", file)
+	}
+	return objects, nil
+}
+
+// decodeStorageObjects decodes a Secret or a ConfigMap, or a list of them. The kind is the kind of the
+// object if it has none, as the items of typed lists do not need to have one.
+func decodeStorageObjects(data []byte, kind string) ([]StorageObject, error) {
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal(data, &typeMeta); err != nil {
+		return nil, err
+	}
+	if typeMeta.Kind == "" {
+		typeMeta.Kind = kind
+	}
+	switch typeMeta.Kind {
+	case "Secret":
+		secret := new(v1.Secret)
+		if err := json.Unmarshal(data, secret); err != nil {
+			return nil, err
+		}
+		secret.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+		return []StorageObject{{Secret: secret}}, nil
+	case "ConfigMap":
+		configMap := new(v1.ConfigMap)
+		if err := json.Unmarshal(data, configMap); err != nil {
+			return nil, err
+		}
+		configMap.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+		return []StorageObject{{ConfigMap: configMap}}, nil
+	case "List", "SecretList", "ConfigMapList":
+		var list struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		var objects []StorageObject
+		for _, item := range list.Items {
+			itemObjects, err := decodeStorageObjects(item, strings.TrimSuffix(typeMeta.Kind, "List"))
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, itemObjects...)
+		}
+		return objects, nil
+	}
+	return nil, errors.Errorf("unsupported kind '%s', it should be a Secret or a ConfigMap", typeMeta.Kind)
+}
+
+// WriteStorageObjects writes the release storage objects to out as YAML documents
+func WriteStorageObjects(out io.Writer, objects []StorageObject) error {
+	for _, object := range objects {
+		var obj interface{} = object.Secret
+		if object.ConfigMap != nil {
+			obj = object.ConfigMap
+		}
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return errors.Wrapf(err, "failed to encode storage object '%s'", object.Name())
+		}
+		if _, err := io.WriteString(out, "---\n"); err != nil {
+			return err
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// meta returns the metadata of the storage object
+func (o StorageObject) meta() *metav1.ObjectMeta {
+	if o.ConfigMap != nil {
+		return &o.ConfigMap.ObjectMeta
+	}
+	return &o.Secret.ObjectMeta
+}
+
+// Name returns the name of the storage object
+func (o StorageObject) Name() string {
+	return o.meta().Name
+}
+
+// Namespace returns the namespace of the storage object
+func (o StorageObject) Namespace() string {
+	return o.meta().Namespace
+}
+
+// HelmVersion returns the Helm version which stored the release, 'v2' or 'v3', from the labels
+// or the name of the storage object
+func (o StorageObject) HelmVersion() (string, error) {
+	labels := o.meta().Labels
+	switch {
+	case labels["owner"] == "helm", strings.HasPrefix(o.Name(), v3StorageObjectPrefix):
+		return "v3", nil
+	case labels["OWNER"] == "TILLER":
+		return "v2", nil
+	}
+	return "", errors.Errorf("storage object '%s' is not a Helm release storage object", o.Name())
+}
+
+// ReleaseData returns the encoded release stored in the storage object
+func (o StorageObject) ReleaseData() (string, error) {
+	var data string
+	var ok bool
+	if o.ConfigMap != nil {
+		data, ok = o.ConfigMap.Data["release"]
+	} else {
+		var b []byte
+		b, ok = o.Secret.Data["release"]
+		data = string(b)
+	}
+	if !ok {
+		return "", errors.Errorf("storage object '%s' has no release data", o.Name())
+	}
+	return data, nil
+}
+
+// SetReleaseData sets the encoded release stored in the storage object
+func (o StorageObject) SetReleaseData(data string) {
+	if o.ConfigMap != nil {
+		o.ConfigMap.Data["release"] = data
+	} else {
+		o.Secret.Data["release"] = []byte(data)
+	}
+}
+
+// SetProvenance adds the provenance labels and annotations to the storage object
+func (o StorageObject) SetProvenance(provenance *Provenance) error {
+	labels, annotations, err := provenance.Metadata()
+	if err != nil {
+		return err
+	}
+	meta := o.meta()
+	if meta.Labels == nil {
+		meta.Labels = make(map[string]string)
+	}
+	for key, value := range labels {
+		meta.Labels[key] = value
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	for key, value := range annotations {
+		meta.Annotations[key] = value
+	}
+	return nil
+}
+
+// ClearServerFields removes the metadata fields set by the API server, so that the storage object can be applied
+func (o StorageObject) ClearServerFields() {
+	meta := o.meta()
+	*meta = restoredObjectMeta(*meta, "")
+}
+
+// DecodeReleaseData returns the encoded release of the release data of a storage object, which is
+// base64 encoded and gzip compressed by the Helm storage drivers. Releases stored before the drivers
+// compressed them are not compressed.
+func DecodeReleaseData(data string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(b, magicGzip) {
+		return b, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// EncodeReleaseData returns the release data of a storage object for an encoded release, as the Helm
+// storage drivers store it
+func EncodeReleaseData(b []byte) (string, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(b); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ReleaseFilter selects the releases to map when mapping many releases.
+// A release is selected when it matches every filter which is set.
+type ReleaseFilter struct {
+	// Selector is a label selector of the storage objects of the releases.
+	// Only equality-based requirements are supported e.g. team=a,tier=web.
+	Selector string
+
+	// Names are glob patterns of the names of the releases to map e.g. team-a-*
+	Names []string
+
+	// Charts are the names of the charts of the releases to map
+	Charts []string
+
+	// Exclude are glob patterns of the names of releases not to map
+	Exclude []string
+}
+
+// Validate checks that the selector and the glob patterns of the filter are valid
+func (f ReleaseFilter) Validate() error {
+	if _, err := f.SelectorLabels(); err != nil {
+		return err
+	}
+	for _, pattern := range append(append([]string{}, f.Names...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Errorf("invalid release name pattern: %s", pattern)
+		}
+	}
+	return nil
+}
+
+// SelectorLabels returns the labels required by the selector of the filter
+func (f ReleaseFilter) SelectorLabels() (map[string]string, error) {
+	set, err := labels.ConvertSelectorToLabelsMap(strings.ReplaceAll(f.Selector, "==", "="))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid label selector '%s', only equality-based requirements are supported", f.Selector)
+	}
+	return set, nil
+}
+
+// Matches returns true if a release with the name and chart name is selected by the
+// name, chart and exclude filters. The selector is matched by the release storage.
+func (f ReleaseFilter) Matches(name, chart string) bool {
+	if len(f.Names) > 0 && !matchesAny(f.Names, name) {
+		return false
+	}
+	if len(f.Charts) > 0 && !containsString(f.Charts, chart) {
+		return false
+	}
+	return !matchesAny(f.Exclude, name)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
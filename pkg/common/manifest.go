@@ -0,0 +1,365 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// documentSeparator matches the "---" line between documents of a manifest
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*(?:\r?\n|$)`)
+
+// manifest is a release manifest split into its YAML documents.
+// Documents keep their original text and only the entries a conversion
+// changes are written again, so anything not targeted by a mapping is
+// preserved as is, including key order, indentation and comments.
+type manifest struct {
+	documents []*document
+}
+
+// document is a single YAML document of a manifest
+type document struct {
+	// separator is the "---" line preceding the document, empty for the first document
+	separator string
+
+	// content is the text of the document
+	content string
+}
+
+func parseManifest(m string) *manifest {
+	parsed := &manifest{}
+	separator := ""
+	start := 0
+	for _, loc := range documentSeparator.FindAllStringIndex(m, -1) {
+		parsed.documents = append(parsed.documents, &document{separator: separator, content: m[start:loc[0]]})
+		separator = m[loc[0]:loc[1]]
+		start = loc[1]
+	}
+	parsed.documents = append(parsed.documents, &document{separator: separator, content: m[start:]})
+	return parsed
+}
+
+// String returns the manifest with its documents joined by their original separators
+func (m *manifest) String() string {
+	var sb strings.Builder
+	for _, doc := range m.documents {
+		sb.WriteString(doc.separator)
+		sb.WriteString(doc.content)
+	}
+	return sb.String()
+}
+
+// gvk returns the GroupVersionKind of the document
+func (d *document) gvk() schema.GroupVersionKind {
+	return documentGVK(d.content)
+}
+
+// metadata returns the name and namespace of the resource in the document
+func (d *document) metadata() (name, namespace string) {
+	doc, err := parseDocument(d.content)
+	if err != nil || doc == nil {
+		return "", ""
+	}
+	root := doc.Content[0]
+	return scalarValue(lookup(root, "metadata", "name")), scalarValue(lookup(root, "metadata", "namespace"))
+}
+
+// setGVK sets the apiVersion and kind of the document. The values are replaced
+// in the document text, so that the rest of the document is left as is.
+func (d *document) setGVK(gvk schema.GroupVersionKind) error {
+	doc, err := parseDocument(d.content)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return errors.New("document is not a Kubernetes resource")
+	}
+	root := doc.Content[0]
+	apiVersionNode, kindNode := mapValue(root, "apiVersion"), mapValue(root, "kind")
+	if apiVersionNode == nil || kindNode == nil {
+		return errors.New("document has no apiVersion or kind")
+	}
+
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	content, ok := replaceScalars(d.content, map[*yaml.Node]string{apiVersionNode: apiVersion, kindNode: kind})
+	if !ok {
+		// fall back to encoding the entries when the values cannot be found in the text
+		e, err := newEditDocument(d.content)
+		if err != nil {
+			return err
+		}
+		mapValue(e.root, "apiVersion").Value, mapValue(e.root, "kind").Value = apiVersion, kind
+		if content, err = e.String(); err != nil {
+			return err
+		}
+	}
+	d.content = content
+	return nil
+}
+
+// annotation returns the value of an annotation of the resource in the document, and whether it is set
+func (d *document) annotation(key string) (string, bool) {
+	doc, err := parseDocument(d.content)
+	if err != nil || doc == nil {
+		return "", false
+	}
+	value := lookup(doc.Content[0], "metadata", "annotations", key)
+	return scalarValue(value), value != nil
+}
+
+// setAnnotation sets an annotation of the resource in the document. Only the annotations
+// are written again, so that the rest of the document is left as is.
+func (d *document) setAnnotation(key, value string) error {
+	e, err := newEditDocument(d.content)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return errors.New("document is not a Kubernetes resource")
+	}
+	metadata := mappingValue(e.root, "metadata")
+	annotations := mappingValue(metadata, "annotations")
+	if node := mapValue(annotations, key); node != nil {
+		node.Kind, node.Tag, node.Style, node.Value = yaml.ScalarNode, "!!str", 0, value
+	} else {
+		insertMapValue(annotations, key, newScalar(value, "!!str"), "")
+	}
+	content, err := e.String()
+	if err != nil {
+		return err
+	}
+	d.content = content
+	return nil
+}
+
+// mappingValue returns the mapping value of key in a mapping node, which is added or replaced
+// if it is not set or is not a mapping e.g. an empty value
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	value := mapValue(n, key)
+	if value != nil && value.Kind == yaml.MappingNode {
+		return value
+	}
+	mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if value != nil {
+		*value = *mapping
+		return value
+	}
+	insertMapValue(n, key, mapping, "")
+	return mapping
+}
+
+// parseDocument parses the content of a document. It returns nil if the
+// document is empty or is not a mapping.
+func parseDocument(content string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	return &doc, nil
+}
+
+func documentGVK(content string) schema.GroupVersionKind {
+	doc, err := parseDocument(content)
+	if err != nil || doc == nil {
+		return schema.GroupVersionKind{}
+	}
+	root := doc.Content[0]
+	return schema.FromAPIVersionAndKind(scalarValue(mapValue(root, "apiVersion")), scalarValue(mapValue(root, "kind")))
+}
+
+func encodeDocument(doc *yaml.Node) (string, error) {
+	content, err := encodeNode(doc)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode manifest document")
+	}
+	return content, nil
+}
+
+// mapValue returns the value of key in a mapping node, or nil if the key is not set
+func mapValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// lookup walks the mapping keys in path starting from n
+func lookup(n *yaml.Node, path ...string) *yaml.Node {
+	for _, key := range path {
+		n = mapValue(n, key)
+	}
+	return n
+}
+
+// insertMapValue adds key to a mapping node before the key named before,
+// or at the end when before is not set
+func insertMapValue(n *yaml.Node, key string, value *yaml.Node, before string) {
+	keyNode := newScalar(key, "!!str")
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == before {
+			content := append([]*yaml.Node{}, n.Content[:i]...)
+			content = append(content, keyNode, value)
+			n.Content = append(content, n.Content[i:]...)
+			return
+		}
+	}
+	n.Content = append(n.Content, keyNode, value)
+}
+
+// deleteMapValue removes key from a mapping node and returns its value, or nil if the key is not set
+func deleteMapValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			value := n.Content[i+1]
+			n.Content = append(n.Content[:i], n.Content[i+2:]...)
+			return value
+		}
+	}
+	return nil
+}
+
+// renameMapKey renames key in a mapping node, keeping its position
+func renameMapKey(n *yaml.Node, key, newKey string) {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content[i].Value = newKey
+			return
+		}
+	}
+}
+
+// firstKey returns the first key of a mapping node, or an empty string if it has none
+func firstKey(n *yaml.Node) string {
+	if n == nil || n.Kind != yaml.MappingNode || len(n.Content) == 0 {
+		return ""
+	}
+	return n.Content[0].Value
+}
+
+// sequenceItems returns the items of a sequence node
+func sequenceItems(n *yaml.Node) []*yaml.Node {
+	if n == nil || n.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return n.Content
+}
+
+func newScalar(value, tag string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
+
+// replaceScalars replaces the values of single line scalar nodes in the text
+// they were parsed from. It returns false if a node cannot be found in the text.
+func replaceScalars(content string, values map[*yaml.Node]string) (string, bool) {
+	type replacement struct {
+		start, end int
+		token      string
+	}
+	var replacements []replacement
+	for n, value := range values {
+		start, ok := nodeOffset(content, n)
+		token := scalarToken(n.Style, n.Value)
+		if !ok || token == "" || !strings.HasPrefix(content[start:], token) {
+			return content, false
+		}
+		replacements = append(replacements, replacement{start: start, end: start + len(token), token: scalarToken(n.Style, value)})
+	}
+
+	// replace from the end of the text so that the offsets stay valid
+	sort.Slice(replacements, func(i, j int) bool { return replacements[i].start > replacements[j].start })
+	for _, r := range replacements {
+		content = content[:r.start] + r.token + content[r.end:]
+	}
+	return content, true
+}
+
+// nodeOffset returns the byte offset of a node in the text it was parsed from
+func nodeOffset(content string, n *yaml.Node) (int, bool) {
+	offset := 0
+	for line := 1; line < n.Line; line++ {
+		i := strings.IndexByte(content[offset:], '\n')
+		if i < 0 {
+			return 0, false
+		}
+		offset += i + 1
+	}
+	for column := 1; column < n.Column; column++ {
+		r, size := utf8.DecodeRuneInString(content[offset:])
+		if size == 0 || r == '\n' {
+			return 0, false
+		}
+		offset += size
+	}
+	return offset, true
+}
+
+// scalarToken returns the text of a scalar value written in the given style,
+// or an empty string if the style is not supported
+func scalarToken(style yaml.Style, value string) string {
+	switch style {
+	case 0:
+		return value
+	case yaml.SingleQuotedStyle:
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	case yaml.DoubleQuotedStyle:
+		if strings.ContainsAny(value, "\"\\") {
+			return ""
+		}
+		return `"` + value + `"`
+	}
+	return ""
+}
+
+func scalarValue(n *yaml.Node) string {
+	if n == nil || n.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return n.Value
+}
+
+func copyNode(n *yaml.Node) *yaml.Node {
+	if n == nil {
+		return nil
+	}
+	c := *n
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		c.Content[i] = copyNode(child)
+	}
+	return &c
+}
@@ -0,0 +1,257 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	extensionsDeployment = schema.GroupVersionKind{Group: "extensions", Version: "v1beta1", Kind: "Deployment"}
+	appsDeployment       = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+)
+
+const deploymentManifest = `# Source: web/templates/deployment.yaml
+apiVersion: extensions/v1beta1   # old api
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  # the replicas are scaled by an autoscaler
+  replicas: 2
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      tolerations:
+      - key: dedicated
+        operator: Exists
+      containers:
+      - name: web
+        image: "nginx:1.17"   # pinned
+        args: [--port, "8080"]
+`
+
+func TestParseManifestKeepsText(t *testing.T) {
+	manifests := []string{
+		"",
+		deploymentManifest,
+		"---\n" + deploymentManifest + "---\n" + deploymentManifest,
+		"--- \t\n" + deploymentManifest + "---   \n" + deploymentManifest,
+		strings.ReplaceAll("---\n"+deploymentManifest+"---\n"+deploymentManifest, "\n", "\r\n"),
+		"---\nnot: [valid\n---\n" + deploymentManifest,
+	}
+	for _, m := range manifests {
+		if got := parseManifest(m).String(); got != m {
+			t.Errorf("expected the manifest to be kept as is, got:\n%q\nwant:\n%q", got, m)
+		}
+	}
+}
+
+func TestParseManifestSeparators(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		docs     int
+		last     schema.GroupVersionKind
+	}{
+		{name: "no separator", manifest: deploymentManifest, docs: 1, last: extensionsDeployment},
+		{name: "leading separator", manifest: "---\n" + deploymentManifest, docs: 2, last: extensionsDeployment},
+		{name: "trailing spaces", manifest: deploymentManifest + "---  \t\n" + deploymentManifest, docs: 2, last: extensionsDeployment},
+		{name: "CRLF", manifest: strings.ReplaceAll(deploymentManifest+"---\n"+deploymentManifest, "\n", "\r\n"), docs: 2, last: extensionsDeployment},
+		{name: "separator at the end", manifest: deploymentManifest + "---", docs: 2},
+		{name: "not a separator", manifest: deploymentManifest + "----\n--- # comment\n", docs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs := parseManifest(tt.manifest).documents
+			if len(docs) != tt.docs {
+				t.Fatalf("expected %d documents, got %d", tt.docs, len(docs))
+			}
+			if gvk := docs[len(docs)-1].gvk(); gvk != tt.last {
+				t.Errorf("expected the last document to be '%s', got '%s'", tt.last, gvk)
+			}
+		})
+	}
+}
+
+func TestDocumentGVKAndMetadata(t *testing.T) {
+	doc := parseManifest(deploymentManifest).documents[0]
+	if gvk := doc.gvk(); gvk != extensionsDeployment {
+		t.Errorf("expected %s, got %s", extensionsDeployment, gvk)
+	}
+	if name, namespace := doc.metadata(); name != "web" || namespace != "" {
+		t.Errorf("expected name 'web' and no namespace, got '%s' and '%s'", name, namespace)
+	}
+}
+
+func TestDocumentsWhichDoNotParse(t *testing.T) {
+	for _, content := range []string{"", "# only a comment\n", "- a list\n", "not: [valid\n", "just a string\n"} {
+		doc := &document{content: content}
+		if gvk := doc.gvk(); !gvk.Empty() {
+			t.Errorf("expected no GroupVersionKind for %q, got %s", content, gvk)
+		}
+		if name, namespace := doc.metadata(); name != "" || namespace != "" {
+			t.Errorf("expected no metadata for %q, got '%s' and '%s'", content, name, namespace)
+		}
+		if err := doc.setGVK(appsDeployment); err == nil {
+			t.Errorf("expected an error setting the GroupVersionKind of %q", content)
+		}
+		if err := doc.setAnnotation(MappedFromAnnotation, "x"); err == nil {
+			t.Errorf("expected an error setting an annotation of %q", content)
+		}
+		if doc.content != content {
+			t.Errorf("expected %q to be left as is, got %q", content, doc.content)
+		}
+	}
+}
+
+func TestSetGVKKeepsDocument(t *testing.T) {
+	doc := parseManifest(deploymentManifest).documents[0]
+	if err := doc.setGVK(appsDeployment); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(deploymentManifest, "apiVersion: extensions/v1beta1   # old api", "apiVersion: apps/v1   # old api", 1)
+	if doc.content != want {
+		t.Errorf("expected only the apiVersion to change, got:\n%s", doc.content)
+	}
+}
+
+func TestSetGVKStyles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "double quoted",
+			content: "apiVersion: \"extensions/v1beta1\"\nkind: \"Deployment\"\n",
+			want:    "apiVersion: \"apps/v1\"\nkind: \"Deployment\"\n",
+		},
+		{
+			name:    "single quoted",
+			content: "apiVersion: 'extensions/v1beta1' # old\nkind: 'Deployment'\n",
+			want:    "apiVersion: 'apps/v1' # old\nkind: 'Deployment'\n",
+		},
+		{
+			name:    "flow style",
+			content: "{apiVersion: extensions/v1beta1, kind: Deployment, metadata: {name: web}}\n",
+			want:    "{apiVersion: apps/v1, kind: Deployment, metadata: {name: web}}\n",
+		},
+		{
+			name:    "key order",
+			content: "kind: Deployment\nmetadata:\n  name: web\napiVersion: extensions/v1beta1\n",
+			want:    "kind: Deployment\nmetadata:\n  name: web\napiVersion: apps/v1\n",
+		},
+		{
+			name:    "CRLF",
+			content: "apiVersion: extensions/v1beta1\r\nkind: Deployment\r\n",
+			want:    "apiVersion: apps/v1\r\nkind: Deployment\r\n",
+		},
+		{
+			name:    "multi-line scalar",
+			content: "apiVersion: \"extensions/\\\n  v1beta1\"\nkind: Deployment\nspec:\n  replicas: 1\n",
+			want:    "apiVersion: \"apps/v1\"\nkind: Deployment\nspec:\n  replicas: 1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &document{content: tt.content}
+			if gvk := doc.gvk(); gvk != extensionsDeployment {
+				t.Fatalf("expected %s, got %s", extensionsDeployment, gvk)
+			}
+			if err := doc.setGVK(appsDeployment); err != nil {
+				t.Fatal(err)
+			}
+			if doc.content != tt.want {
+				t.Errorf("expected:\n%q\ngot:\n%q", tt.want, doc.content)
+			}
+		})
+	}
+}
+
+func TestSetGVKRequiresAPIVersionAndKind(t *testing.T) {
+	doc := &document{content: "kind: Deployment\nmetadata:\n  name: web\n"}
+	if err := doc.setGVK(appsDeployment); err == nil {
+		t.Error("expected an error setting the GroupVersionKind of a document without apiVersion")
+	}
+}
+
+func TestSetAnnotationKeepsDocument(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "no annotations",
+			content: deploymentManifest,
+			want: strings.Replace(deploymentManifest, "  labels:\n    app: web\nspec:",
+				"  labels:\n    app: web\n  annotations:\n    mapkubeapis/mapped-from: extensions/v1beta1, Kind=Deployment\nspec:", 1),
+		},
+		{
+			name:    "other annotations",
+			content: "kind: Deployment\nmetadata:\n  annotations:\n    # set by the chart\n    a: \"1\"  # one\n  name: web\nspec:\n  ports:\n  - port: 80\n",
+			want:    "kind: Deployment\nmetadata:\n  annotations:\n    # set by the chart\n    a: \"1\"  # one\n    mapkubeapis/mapped-from: extensions/v1beta1, Kind=Deployment\n  name: web\nspec:\n  ports:\n  - port: 80\n",
+		},
+		{
+			name:    "annotation replaced",
+			content: "kind: Deployment\nmetadata:\n  annotations:\n    mapkubeapis/mapped-from: 'old'   # replaced\n    b: x\n",
+			want:    "kind: Deployment\nmetadata:\n  annotations:\n    mapkubeapis/mapped-from: extensions/v1beta1, Kind=Deployment   # replaced\n    b: x\n",
+		},
+		{
+			name:    "empty annotations",
+			content: "kind: Deployment\nmetadata:\n  annotations:\n  name: web\n",
+			want:    "kind: Deployment\nmetadata:\n  annotations:\n    mapkubeapis/mapped-from: extensions/v1beta1, Kind=Deployment\n  name: web\n",
+		},
+		{
+			name:    "no metadata",
+			content: "kind: Deployment\nspec:\n  replicas: 1\n",
+			want:    "kind: Deployment\nspec:\n  replicas: 1\nmetadata:\n  annotations:\n    mapkubeapis/mapped-from: extensions/v1beta1, Kind=Deployment\n",
+		},
+		{
+			name:    "CRLF",
+			content: "kind: Deployment\r\nmetadata:\r\n  name: web\r\n",
+			want:    "kind: Deployment\r\nmetadata:\r\n  name: web\r\n  annotations:\r\n    mapkubeapis/mapped-from: extensions/v1beta1, Kind=Deployment\r\n",
+		},
+		{
+			name:    "no final line break",
+			content: "kind: Deployment\nmetadata:\n  name: web",
+			want:    "kind: Deployment\nmetadata:\n  name: web\n  annotations:\n    mapkubeapis/mapped-from: extensions/v1beta1, Kind=Deployment\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &document{content: tt.content}
+			if err := doc.setAnnotation(MappedFromAnnotation, extensionsDeployment.String()); err != nil {
+				t.Fatal(err)
+			}
+			if doc.content != tt.want {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.want, doc.content)
+			}
+			if value, ok := doc.annotation(MappedFromAnnotation); !ok || value != extensionsDeployment.String() {
+				t.Errorf("expected the annotation to be '%s', got '%s'", extensionsDeployment, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	utils "github.com/maorfr/helm-plugin-utils/pkg"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Labels and annotations recording the provenance of a mapped release version on its storage object
+const (
+	// MappedLabel is set to "true" on the storage objects of mapped release versions
+	MappedLabel = "mapkubeapis/mapped"
+
+	// SourceRevisionLabel is the release version which was mapped
+	SourceRevisionLabel = "mapkubeapis/source-revision"
+
+	// KubeVersionLabel is the major and minor Kubernetes version the APIs were mapped for,
+	// as the full version may not be a valid label value
+	KubeVersionLabel = "mapkubeapis/kube-version"
+
+	KubeVersionAnnotation   = "mapkubeapis/kube-version"
+	PluginVersionAnnotation = "mapkubeapis/plugin-version"
+	MapfileHashAnnotation   = "mapkubeapis/mapfile-sha256"
+	RulesAnnotation         = "mapkubeapis/rules"
+)
+
+// MappedFromAnnotation is set on the resources of a manifest mapped with MapOptions.AnnotateResources to the API
+// they were mapped from, along with KubeVersionAnnotation
+const MappedFromAnnotation = "mapkubeapis/mapped-from"
+
+// provenanceDescription starts the provenance in the description of a mapped release version
+const provenanceDescription = "mapkubeapis provenance: "
+
+// Provenance records how a release version was mapped
+type Provenance struct {
+	// PluginVersion is the version of the plugin which mapped the release
+	PluginVersion string `json:"pluginVersion"`
+
+	// MapfileHash is the SHA-256 hash of the mapping file
+	MapfileHash string `json:"mapfileSha256"`
+
+	// Rules are the mappings applied to the manifest
+	Rules []string `json:"rules"`
+
+	// SourceRevision is the release version which was mapped
+	SourceRevision int `json:"sourceRevision"`
+
+	// KubeVersion is the Kubernetes version the APIs were mapped for
+	KubeVersion string `json:"kubeVersion"`
+}
+
+// NewProvenance returns the provenance of a release version mapped with the options, from the report
+// of the release version it was mapped from
+func NewProvenance(mapOptions MapOptions, report *ReleaseReport) (*Provenance, error) {
+	mapfileHash, err := hashFile(mapOptions.MapFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to hash mapping file: %s", mapOptions.MapFile)
+	}
+	kubeVersion, err := GetKubernetesVersion(mapOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	provenance := &Provenance{
+		PluginVersion:  mapOptions.PluginVersion,
+		MapfileHash:    mapfileHash,
+		Rules:          []string{},
+		SourceRevision: report.Revision,
+		KubeVersion:    kubeVersion,
+	}
+	applied := make(map[string]bool)
+	for _, finding := range report.Resources {
+		if finding.Status == StatusApplied && !applied[finding.Rule] {
+			applied[finding.Rule] = true
+			provenance.Rules = append(provenance.Rules, finding.Rule)
+		}
+	}
+	return provenance, nil
+}
+
+// Describe returns the description of a release version with the provenance added as JSON, replacing any
+// provenance it has. The description is in the release record, so the provenance is kept when Helm updates
+// the storage object of the release version, unlike its labels and annotations.
+func (p *Provenance) Describe(description string) (string, error) {
+	data, err := encodeJSON(p)
+	if err != nil {
+		return "", err
+	}
+	if i := strings.Index(description, provenanceDescription); i >= 0 {
+		description = strings.TrimSuffix(strings.TrimSpace(description[:i]), ";")
+	}
+	if description == "" {
+		return provenanceDescription + data, nil
+	}
+	return description + "; " + provenanceDescription + data, nil
+}
+
+// Metadata returns the labels and annotations recording the provenance on a storage object
+func (p *Provenance) Metadata() (map[string]string, map[string]string, error) {
+	rules, err := encodeJSON(p.Rules)
+	if err != nil {
+		return nil, nil, err
+	}
+	labels := map[string]string{
+		MappedLabel:         "true",
+		SourceRevisionLabel: strconv.Itoa(p.SourceRevision),
+		KubeVersionLabel:    semver.MajorMinor(p.KubeVersion),
+	}
+	annotations := map[string]string{
+		KubeVersionAnnotation:   p.KubeVersion,
+		PluginVersionAnnotation: p.PluginVersion,
+		MapfileHashAnnotation:   p.MapfileHash,
+		RulesAnnotation:         rules,
+	}
+	return labels, annotations, nil
+}
+
+// RecordProvenance adds the provenance labels and annotations to the storage object of a mapped release version.
+// They are lost when Helm next updates the storage object, while the provenance in the description is kept.
+func RecordProvenance(mapOptions MapOptions, ref StorageRef, name string, provenance *Provenance) error {
+	clientSet := utils.GetClientSetWithKubeConfig(mapOptions.KubeConfig.File, mapOptions.KubeConfig.Context)
+	if clientSet == nil {
+		return errors.Errorf("kubernetes cluster unreachable")
+	}
+	return recordProvenance(clientSet, ref, name, provenance)
+}
+
+func recordProvenance(clientSet kubernetes.Interface, ref StorageRef, name string, provenance *Provenance) error {
+	labels, annotations, err := provenance.Metadata()
+	if err != nil {
+		return err
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      labels,
+			"annotations": annotations,
+		},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	switch ref.StorageType {
+	case StorageSecrets:
+		_, err = clientSet.CoreV1().Secrets(ref.Namespace).Patch(name, types.MergePatchType, data)
+	case StorageConfigMaps:
+		_, err = clientSet.CoreV1().ConfigMaps(ref.Namespace).Patch(name, types.MergePatchType, data)
+	default:
+		return errors.Errorf("unsupported release storage type: %s", ref.StorageType)
+	}
+	return err
+}
+
+// encodeJSON returns the value as JSON on a single line, without escaping the '>' of the rules
+func encodeJSON(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func hashFile(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+// Statuses of a finding
+const (
+	// StatusApplied is the status of a resource whose API was mapped
+	StatusApplied = "applied"
+
+	// StatusSkipped is the status of a resource whose API did not need mapping
+	// for the Kubernetes version and policy
+	StatusSkipped = "skipped"
+
+	// StatusUnmapped is the status of a resource whose API is deprecated, removed or not served,
+	// but which no mapping maps
+	StatusUnmapped = "unmapped"
+)
+
+// Finding is a resource of a manifest which uses a deprecated or removed API
+type Finding struct {
+	// Source is the path of the template the resource is from
+	Source              string `json:"source,omitempty"`
+	Kind                string `json:"kind"`
+	Name                string `json:"name"`
+	Namespace           string `json:"namespace,omitempty"`
+	FromAPI             string `json:"fromAPI"`
+	ToAPI               string `json:"toAPI"`
+	Rule                string `json:"rule"`
+	DeprecatedInVersion string `json:"deprecatedInVersion,omitempty"`
+	RemovedInVersion    string `json:"removedInVersion,omitempty"`
+
+	// Deprecated and Removed are whether the API is deprecated or removed in the Kubernetes version checked
+	Deprecated bool `json:"deprecated"`
+	Removed    bool `json:"removed"`
+
+	Status string `json:"status"`
+
+	// Reason is why the resource was skipped
+	Reason string `json:"reason,omitempty"`
+}
+
+// ReleaseReport is the result of checking a release for deprecated or removed APIs
+type ReleaseReport struct {
+	// Chart is the path of the chart which was rendered, for the report of a chart
+	Chart     string    `json:"chart,omitempty"`
+	Release   string    `json:"release"`
+	Namespace string    `json:"namespace"`
+	Revision  int       `json:"revision"`
+	Resources []Finding `json:"resources"`
+
+	// Diffs are the changes to the resources of the manifest
+	Diffs []ResourceDiff `json:"diffs,omitempty"`
+
+	// Error is why the release failed to map, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// Report is the result of a run for all the releases checked
+type Report struct {
+	DryRun      bool            `json:"dryRun"`
+	KubeVersion string          `json:"kubeVersion,omitempty"`
+	Releases    []ReleaseReport `json:"releases"`
+}
@@ -0,0 +1,141 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/hickeyma/helm-mapkubeapis/pkg/mapping"
+)
+
+// rule applies a mapping to the documents of a manifest. Structured mappings
+// are matched against the apiVersion and kind of each document, the others
+// against the document text using their regex.
+type rule struct {
+	mapping *mapping.Mapping
+	re      *regexp.Regexp
+
+	// policy is the policy of when the API is mapped
+	policy string
+
+	// version is the Kubernetes version from which the API is mapped, empty if it is never mapped
+	version string
+}
+
+// newRule returns the rule for a mapping, which follows the policy passed
+// unless the mapping has its own
+func newRule(m *mapping.Mapping, policy string) (*rule, error) {
+	r := &rule{mapping: m, policy: policy}
+	if m.Policy != "" {
+		r.policy = m.Policy
+	}
+	if !mapping.IsValidPolicy(r.policy) {
+		return nil, errors.Errorf("Invalid mapping policy '%s' for API: %s", r.policy, describeAPI(m.SourceAPI()))
+	}
+	r.version = m.MappedInVersion(r.policy)
+	if !semver.IsValid(r.version) && !(r.version == "" && r.policy == mapping.PolicyRemoved) {
+		return nil, errors.Errorf("Failed to get the deprecated or removed Kubernetes version for API: %s", describeAPI(m.SourceAPI()))
+	}
+
+	if m.IsStructured() {
+		if m.From == nil || m.To == nil {
+			return nil, errors.Errorf("Failed to get both the API to map from and to for API: %s", describeAPI(m.SourceAPI()))
+		}
+		return r, nil
+	}
+
+	if m.DeprecatedAPI == "" {
+		return nil, errors.Errorf("Failed to get the deprecated API for mapping to API: %s", describeAPI(m.NewAPI))
+	}
+	re, err := regexp.Compile(m.DeprecatedAPI)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to compile the deprecated API: %s", describeAPI(m.DeprecatedAPI))
+	}
+	r.re = re
+	return r, nil
+}
+
+// matches returns true if the document contains the API to be mapped
+func (r *rule) matches(doc *document) bool {
+	if r.re != nil {
+		return r.re.MatchString(doc.content)
+	}
+	return doc.gvk() == toSchemaGVK(r.mapping.From)
+}
+
+// apply maps the API of the document and converts its schema if needed
+func (r *rule) apply(doc *document) error {
+	from := doc.gvk()
+	if r.re != nil {
+		doc.content = r.re.ReplaceAllString(doc.content, r.mapping.NewAPI)
+	} else if err := doc.setGVK(toSchemaGVK(r.mapping.To)); err != nil {
+		return err
+	}
+
+	content, err := convertDocument(doc.content, from)
+	if err != nil {
+		return err
+	}
+	doc.content = content
+	return nil
+}
+
+// target returns the API the document is mapped to
+func (r *rule) target(doc *document) schema.GroupVersionKind {
+	if r.re != nil {
+		return documentGVK(r.re.ReplaceAllString(doc.content, r.mapping.NewAPI))
+	}
+	return toSchemaGVK(r.mapping.To)
+}
+
+// finding returns the finding for a document matched by the rule, before it is mapped
+func (r *rule) finding(doc *document, kubeVersion string) Finding {
+	from, to := doc.gvk(), r.target(doc)
+	name, namespace := doc.metadata()
+	return Finding{
+		Source:              documentSource(doc.content),
+		Kind:                from.Kind,
+		Name:                name,
+		Namespace:           namespace,
+		FromAPI:             from.GroupVersion().String(),
+		ToAPI:               to.GroupVersion().String(),
+		Rule:                describeAPI(r.mapping.SourceAPI()) + " -> " + describeAPI(r.mapping.TargetAPI()),
+		DeprecatedInVersion: r.mapping.DeprecatedInVersion,
+		RemovedInVersion:    r.mapping.RemovedInVersion,
+		Deprecated:          inVersion(r.mapping.DeprecatedInVersion, kubeVersion),
+		Removed:             inVersion(r.mapping.RemovedInVersion, kubeVersion),
+	}
+}
+
+// inVersion returns true if the version is set and is not later than the Kubernetes version
+func inVersion(version, kubeVersion string) bool {
+	return semver.IsValid(version) && semver.Compare(version, kubeVersion) <= 0
+}
+
+func toSchemaGVK(gvk *mapping.GroupVersionKind) schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind}
+}
+
+// describeAPI returns an API as described in a mapping on a single line
+func describeAPI(api string) string {
+	return strings.ReplaceAll(api, "\n", " ")
+}
@@ -0,0 +1,417 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// editDocument is a parsed manifest document whose nodes can be changed. It is written back by
+// splicing only the entries which changed into the original text, so that the indentation,
+// comments and layout of everything else are left as is.
+type editDocument struct {
+	content string
+	doc     *yaml.Node
+	root    *yaml.Node
+
+	// lineStarts are the offsets of the lines of the content
+	lineStarts []int
+
+	// origins are the nodes as they were parsed, with their original children
+	origins map[*yaml.Node]*yaml.Node
+}
+
+// edit replaces the text from start to end
+type edit struct {
+	start, end int
+	text       string
+}
+
+// newEditDocument parses the content of a document. It returns nil if the
+// document is empty or is not a mapping.
+func newEditDocument(content string) (*editDocument, error) {
+	doc, err := parseDocument(content)
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	e := &editDocument{content: content, doc: doc, root: doc.Content[0], lineStarts: []int{0}, origins: make(map[*yaml.Node]*yaml.Node)}
+	for i := 0; i < len(content); i++ {
+		if content[i] == '\n' {
+			e.lineStarts = append(e.lineStarts, i+1)
+		}
+	}
+	e.record(doc)
+	return e, nil
+}
+
+func (e *editDocument) record(n *yaml.Node) {
+	orig := *n
+	orig.Content = append([]*yaml.Node(nil), n.Content...)
+	e.origins[n] = &orig
+	for _, child := range n.Content {
+		e.record(child)
+	}
+}
+
+// String returns the text of the document with the changes of its nodes
+func (e *editDocument) String() (string, error) {
+	if !e.changed(e.root) {
+		return e.content, nil
+	}
+	if orig := e.origins[e.root]; len(orig.Content) > 0 {
+		// the lines before the first key, e.g. comments, are kept as is
+		header := e.content[:e.lineStart(orig.Content[0].Line)]
+		if body, ok := e.spliceMapping(e.root, len(e.content)); ok {
+			return header + body, nil
+		}
+	}
+	return encodeDocument(e.doc)
+}
+
+// changed returns whether a node or any of its children changed since it was parsed
+func (e *editDocument) changed(n *yaml.Node) bool {
+	orig := e.origins[n]
+	if orig == nil || n.Kind != orig.Kind || n.Style != orig.Style || n.Tag != orig.Tag || n.Value != orig.Value ||
+		n.Anchor != orig.Anchor || n.Alias != orig.Alias || len(n.Content) != len(orig.Content) {
+		return true
+	}
+	for i, child := range n.Content {
+		if child != orig.Content[i] || e.changed(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// spliceMapping returns the text of a block mapping which was parsed from the text up to end,
+// starting at the beginning of the line of its first key. It returns false if it cannot be spliced.
+func (e *editDocument) spliceMapping(n *yaml.Node, end int) (string, bool) {
+	orig := e.origins[n]
+	if orig == nil || orig.Kind != yaml.MappingNode || n.Kind != yaml.MappingNode || n.Style&yaml.FlowStyle != 0 || len(orig.Content) == 0 {
+		return "", false
+	}
+
+	// the entries of the mapping span from their key to the comments before the key of the next entry,
+	// which are kept with the next entry, and the comments after the last entry are kept at the end
+	var keys []*yaml.Node
+	for i := 0; i+1 < len(orig.Content); i += 2 {
+		keys = append(keys, orig.Content[i])
+	}
+	spans, trailer, ok := e.spans(keys, end)
+	if !ok {
+		return "", false
+	}
+	prefix := e.content[e.lineStart(keys[0].Line):spans[keys[0]].start]
+	indent := strings.Repeat(" ", utf8.RuneCountInString(prefix))
+	for _, key := range keys[1:] {
+		if strings.TrimLeft(e.content[e.lineStart(key.Line):spans[key].start], " ") != "" {
+			return "", false
+		}
+	}
+	values := make(map[*yaml.Node]*yaml.Node)
+	for i := 0; i+1 < len(orig.Content); i += 2 {
+		values[orig.Content[i]] = orig.Content[i+1]
+	}
+
+	var chunks []string
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		span, parsed := spans[key]
+		text, ok := "", false
+		if parsed {
+			text, ok = e.spliceEntry(key, value, values[key], span.start, span.end)
+		}
+		if !ok {
+			var err error
+			if text, err = e.encodeEntry(key, value, indent); err != nil {
+				return "", false
+			}
+		}
+		if len(chunks) == 0 {
+			chunks = append(chunks, span.lead+prefix+text)
+		} else {
+			chunks = append(chunks, span.lead+indent+text)
+		}
+	}
+	if len(chunks) == 0 {
+		return "", false
+	}
+	return joinLines(append(chunks, trailer)), true
+}
+
+// spliceSequence returns the text of a block sequence which was parsed from the text up to end,
+// starting at the beginning of the line of its first item. It returns false if it cannot be spliced.
+func (e *editDocument) spliceSequence(n *yaml.Node, end int) (string, bool) {
+	orig := e.origins[n]
+	if orig == nil || orig.Kind != yaml.SequenceNode || n.Kind != yaml.SequenceNode || n.Style&yaml.FlowStyle != 0 || len(orig.Content) == 0 {
+		return "", false
+	}
+
+	// the items of the sequence span from the line of their "-" as the entries of a mapping do
+	spans, trailer, ok := e.spans(orig.Content, end)
+	if !ok {
+		return "", false
+	}
+	for _, item := range orig.Content {
+		if strings.TrimSpace(e.content[e.lineStart(item.Line):spans[item].start]) != "-" {
+			return "", false
+		}
+	}
+
+	var chunks []string
+	for _, item := range n.Content {
+		span, parsed := spans[item]
+		if !parsed {
+			return "", false
+		}
+		start := e.lineStart(item.Line)
+		switch {
+		case !e.changed(item):
+			chunks = append(chunks, span.lead+e.content[start:span.end])
+		case item.Kind == yaml.MappingNode:
+			text, ok := e.spliceMapping(item, span.end)
+			if !ok {
+				return "", false
+			}
+			chunks = append(chunks, span.lead+text)
+		case item.Kind == yaml.ScalarNode:
+			replaced, ok := e.replaceScalar(item)
+			if !ok {
+				return "", false
+			}
+			chunks = append(chunks, span.lead+applyEdits(e.content, start, span.end, []edit{replaced}))
+		default:
+			return "", false
+		}
+	}
+	if len(chunks) == 0 {
+		return "", false
+	}
+	return joinLines(append(chunks, trailer)), true
+}
+
+// span is the text of an entry of a mapping or an item of a sequence, from start to end,
+// with the lines of comments before it
+type span struct {
+	start, end int
+	lead       string
+}
+
+// spans returns the spans of the entries or items of a collection starting at the nodes, which was
+// parsed from the text up to end, and the lines of comments after the last one. It returns false if
+// the nodes are not each on their own line.
+func (e *editDocument) spans(nodes []*yaml.Node, end int) (map[*yaml.Node]span, string, bool) {
+	spans := make(map[*yaml.Node]span)
+	lead := ""
+	for i, n := range nodes {
+		start, ok := e.offset(n)
+		if !ok {
+			return nil, "", false
+		}
+		spanEnd := end
+		nextLead := ""
+		if i+1 < len(nodes) {
+			next := nodes[i+1]
+			if next.Line <= n.Line {
+				return nil, "", false
+			}
+			nextStart := e.lineStart(next.Line)
+			spanEnd = e.commentsBefore(nextStart, e.lineStart(n.Line+1))
+			nextLead = e.content[spanEnd:nextStart]
+		} else {
+			spanEnd = e.commentsBefore(end, e.lineStart(n.Line+1))
+		}
+		if spanEnd > end || spanEnd < start {
+			return nil, "", false
+		}
+		spans[n] = span{start: start, end: spanEnd, lead: lead}
+		lead = nextLead
+	}
+	last := spans[nodes[len(nodes)-1]]
+	return spans, e.content[last.end:end], true
+}
+
+// commentsBefore returns the start of the lines which are blank or only have a comment, ending at
+// the line starting at offset, and starting no earlier than the line starting at limit
+func (e *editDocument) commentsBefore(offset, limit int) int {
+	line := sort.SearchInts(e.lineStarts, offset)
+	for line > 0 && e.lineStarts[line-1] >= limit {
+		text := strings.TrimSpace(e.content[e.lineStarts[line-1]:offset])
+		if text != "" && !strings.HasPrefix(text, "#") {
+			break
+		}
+		offset = e.lineStarts[line-1]
+		line--
+	}
+	return offset
+}
+
+// spliceEntry returns the text of an entry of a mapping which was parsed from start to end,
+// starting at its key. It returns false if it cannot be spliced.
+func (e *editDocument) spliceEntry(key, value, origValue *yaml.Node, start, end int) (string, bool) {
+	if value != origValue {
+		return "", false
+	}
+	var edits []edit
+	if e.changed(key) {
+		replaced, ok := e.replaceScalar(key)
+		if !ok {
+			return "", false
+		}
+		edits = append(edits, replaced)
+	}
+	if e.changed(value) {
+		blockValue := value.Style&yaml.FlowStyle == 0 && value.Line > key.Line
+		switch {
+		case value.Kind == yaml.ScalarNode:
+			replaced, ok := e.replaceScalar(value)
+			if !ok {
+				return "", false
+			}
+			edits = append(edits, replaced)
+		case value.Kind == yaml.MappingNode && blockValue:
+			body, ok := e.spliceMapping(value, end)
+			if !ok {
+				return "", false
+			}
+			edits = append(edits, edit{start: e.lineStart(value.Line), end: end, text: body})
+		case value.Kind == yaml.SequenceNode && blockValue:
+			body, ok := e.spliceSequence(value, end)
+			if !ok {
+				return "", false
+			}
+			edits = append(edits, edit{start: e.lineStart(value.Line), end: end, text: body})
+		default:
+			return "", false
+		}
+	}
+	return applyEdits(e.content, start, end, edits), true
+}
+
+// replaceScalar returns the edit replacing the text of a scalar node with its new value,
+// or false if the value cannot be written on a single line in place of the original text
+func (e *editDocument) replaceScalar(n *yaml.Node) (edit, bool) {
+	orig := e.origins[n]
+	if orig == nil || orig.Kind != yaml.ScalarNode || n.Kind != yaml.ScalarNode {
+		return edit{}, false
+	}
+	start, ok := e.offset(n)
+	token := scalarToken(orig.Style, orig.Value)
+	if !ok || token == "" || !strings.HasPrefix(e.content[start:], token) {
+		return edit{}, false
+	}
+	scalar := &yaml.Node{Kind: yaml.ScalarNode, Style: n.Style, Tag: n.Tag, Value: n.Value}
+	text, err := encodeNode(scalar)
+	text = strings.TrimSuffix(text, "\n")
+	if err != nil || strings.Contains(text, "\n") {
+		return edit{}, false
+	}
+	return edit{start: start, end: start + len(token), text: text}, true
+}
+
+// encodeEntry returns the text of a mapping entry encoded with the indentation of its mapping,
+// except on its first line
+func (e *editDocument) encodeEntry(key, value *yaml.Node, indent string) (string, error) {
+	text, err := encodeNode(&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{key, value}})
+	if err != nil {
+		return "", err
+	}
+	lines := strings.SplitAfter(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+	text = strings.Join(lines, "")
+	if strings.Contains(e.content, "\r\n") {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	return text, nil
+}
+
+// offset returns the offset of a parsed node in the content
+func (e *editDocument) offset(n *yaml.Node) (int, bool) {
+	if n.Line < 1 || n.Line > len(e.lineStarts) {
+		return 0, false
+	}
+	offset := e.lineStarts[n.Line-1]
+	for column := 1; column < n.Column; column++ {
+		r, size := utf8.DecodeRuneInString(e.content[offset:])
+		if size == 0 || r == '\n' {
+			return 0, false
+		}
+		offset += size
+	}
+	return offset, true
+}
+
+// lineStart returns the offset of the start of a line of the content
+func (e *editDocument) lineStart(line int) int {
+	if line < 1 {
+		return 0
+	}
+	if line > len(e.lineStarts) {
+		return len(e.content)
+	}
+	return e.lineStarts[line-1]
+}
+
+// applyEdits returns the text from start to end with the edits applied
+func applyEdits(content string, start, end int, edits []edit) string {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var sb strings.Builder
+	for _, ed := range edits {
+		sb.WriteString(content[start:ed.start])
+		sb.WriteString(ed.text)
+		start = ed.end
+	}
+	sb.WriteString(content[start:end])
+	return sb.String()
+}
+
+// joinLines joins chunks of lines, adding a line break to any chunk followed by another which has none
+func joinLines(chunks []string) string {
+	var sb strings.Builder
+	for _, chunk := range chunks {
+		if chunk == "" {
+			continue
+		}
+		if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(chunk)
+	}
+	return sb.String()
+}
+
+func encodeNode(n *yaml.Node) (string, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(n); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
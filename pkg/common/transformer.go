@@ -0,0 +1,81 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Transformer converts the schema of a resource when its API is mapped
+// from one GroupVersionKind to another
+type Transformer interface {
+	// Transform changes the resource in-place and returns true if it was changed.
+	// The resource is the root mapping node of a manifest document and already
+	// has the apiVersion and kind of the API it was mapped to.
+	Transform(resource *yaml.Node) (bool, error)
+}
+
+// TransformerFunc is an adapter to allow the use of ordinary functions as Transformers
+type TransformerFunc func(resource *yaml.Node) (bool, error)
+
+// Transform calls f(resource)
+func (f TransformerFunc) Transform(resource *yaml.Node) (bool, error) {
+	return f(resource)
+}
+
+type transformerKey struct {
+	from schema.GroupVersionKind
+	to   schema.GroupVersionKind
+}
+
+var (
+	transformersMu sync.RWMutex
+	transformers   = make(map[transformerKey]Transformer)
+)
+
+// RegisterTransformer registers the Transformer to run on resources mapped from one
+// GroupVersionKind to another. Only one Transformer can be registered for a pair.
+func RegisterTransformer(from, to schema.GroupVersionKind, transformer Transformer) error {
+	transformersMu.Lock()
+	defer transformersMu.Unlock()
+
+	key := transformerKey{from: from, to: to}
+	if _, exists := transformers[key]; exists {
+		return errors.Errorf("transformer from '%s' to '%s' is already registered", from, to)
+	}
+	transformers[key] = transformer
+	return nil
+}
+
+// GetTransformer returns the Transformer registered for resources mapped from one
+// GroupVersionKind to another, or nil if there is none
+func GetTransformer(from, to schema.GroupVersionKind) Transformer {
+	transformersMu.RLock()
+	defer transformersMu.RUnlock()
+
+	return transformers[transformerKey{from: from, to: to}]
+}
+
+func mustRegisterTransformer(from, to schema.GroupVersionKind, transformer Transformer) {
+	if err := RegisterTransformer(from, to, transformer); err != nil {
+		panic(err)
+	}
+}
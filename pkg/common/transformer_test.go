@@ -0,0 +1,117 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// the transformers of the tests are for APIs of their own group, and are registered once as the registry is global
+var (
+	widgetV1alpha1   = schema.GroupVersionKind{Group: "transformer.test", Version: "v1alpha1", Kind: "Widget"}
+	widgetV1beta1    = schema.GroupVersionKind{Group: "transformer.test", Version: "v1beta1", Kind: "Widget"}
+	widgetV1         = schema.GroupVersionKind{Group: "transformer.test", Version: "v1", Kind: "Widget"}
+	sprocketV1alpha1 = schema.GroupVersionKind{Group: "transformer.test", Version: "v1alpha1", Kind: "Sprocket"}
+	sprocketV1       = schema.GroupVersionKind{Group: "transformer.test", Version: "v1", Kind: "Sprocket"}
+
+	transformErr = errors.New("cannot convert")
+)
+
+func init() {
+	mustRegisterTransformer(widgetV1alpha1, widgetV1, setSize("large", true))
+	mustRegisterTransformer(widgetV1beta1, widgetV1, setSize("large", false))
+	mustRegisterTransformer(sprocketV1alpha1, sprocketV1, TransformerFunc(func(*yaml.Node) (bool, error) { return false, transformErr }))
+}
+
+// setSize is a transformer which sets spec.size, and reports the change as changed is set
+func setSize(size string, changed bool) Transformer {
+	return TransformerFunc(func(resource *yaml.Node) (bool, error) {
+		node := lookup(resource, "spec", "size")
+		if node == nil {
+			return false, nil
+		}
+		node.Value = size
+		return changed, nil
+	})
+}
+
+func TestRegisterTransformerTwice(t *testing.T) {
+	if err := RegisterTransformer(widgetV1alpha1, widgetV1, setSize("small", true)); err == nil {
+		t.Error("expected an error registering a second transformer for the same APIs")
+	}
+	got, err := convertDocument("apiVersion: transformer.test/v1\nkind: Widget\nspec:\n  size: medium\n", widgetV1alpha1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "apiVersion: transformer.test/v1\nkind: Widget\nspec:\n  size: large\n"; got != want {
+		t.Errorf("expected the first transformer registered to be kept, got:\n%s", got)
+	}
+}
+
+func TestGetTransformer(t *testing.T) {
+	if GetTransformer(widgetV1alpha1, widgetV1) == nil {
+		t.Error("expected the transformer registered for the APIs")
+	}
+	for _, key := range []struct{ from, to schema.GroupVersionKind }{
+		{widgetV1, widgetV1alpha1},
+		{widgetV1alpha1, widgetV1beta1},
+		{sprocketV1alpha1, widgetV1},
+		{widgetV1alpha1, schema.GroupVersionKind{Group: "transformer.test", Version: "v1", Kind: "Gizmo"}},
+	} {
+		if GetTransformer(key.from, key.to) != nil {
+			t.Errorf("expected no transformer from '%s' to '%s'", key.from, key.to)
+		}
+	}
+}
+
+func TestConvertDocumentRunsTransformer(t *testing.T) {
+	content := "apiVersion: transformer.test/v1\nkind: Widget\nspec:\n  # the size\n  size: small\n  color: red\n"
+	got, err := convertDocument(content, widgetV1alpha1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "apiVersion: transformer.test/v1\nkind: Widget\nspec:\n  # the size\n  size: large\n  color: red\n"; got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	// no transformer is registered from the API the document has
+	if got, err := convertDocument(content, widgetV1); err != nil || got != content {
+		t.Errorf("expected the document to be left as is, got:\n%s", got)
+	}
+}
+
+func TestConvertDocumentUnchanged(t *testing.T) {
+	content := "apiVersion: transformer.test/v1\nkind: Widget\nspec:\n  size:   small   # kept\n"
+	got, err := convertDocument(content, widgetV1beta1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != content {
+		t.Errorf("expected the document to be left as is when the transformer reports no change, got:\n%s", got)
+	}
+}
+
+func TestConvertDocumentError(t *testing.T) {
+	_, err := convertDocument("apiVersion: transformer.test/v1\nkind: Sprocket\n", sprocketV1alpha1)
+	if errors.Cause(err) != transformErr {
+		t.Errorf("expected the error of the transformer, got: %v", err)
+	}
+}
@@ -16,18 +16,112 @@ limitations under the License.
 
 package mapping
 
+import (
+	"fmt"
+)
+
+const (
+	// PolicyDeprecated maps an API from the Kubernetes version it is deprecated in,
+	// or removed in if it has no deprecated version
+	PolicyDeprecated = "deprecated"
+
+	// PolicyRemoved maps an API only from the Kubernetes version it is removed in
+	PolicyRemoved = "removed"
+)
+
 // Mapping describes mappings which defines the Kubernetes
-// API deprecations and the new replacement API
+// API deprecations and the new replacement API.
+// An API is described either as a regex and replacement string of the manifest
+// text with DeprecatedAPI and NewAPI, or as a GroupVersionKind with From and To.
 type Mapping struct {
 	// From is the API looking to be mapped
-	DeprecatedAPI string `json:"deprecatedAPI"`
+	DeprecatedAPI string `json:"deprecatedAPI,omitempty"`
 
 	// To is the API to be mapped to
-	NewAPI string `json:"newAPI"`
+	NewAPI string `json:"newAPI,omitempty"`
+
+	// From is the API looking to be mapped, matched against the apiVersion and kind of a resource
+	From *GroupVersionKind `json:"from,omitempty"`
+
+	// To is the API to be mapped to, when From is set
+	To *GroupVersionKind `json:"to,omitempty"`
 
 	// Kubernetes version API is deprecated in
 	DeprecatedInVersion string `json:"deprecatedInVersion,omitempty"`
 
 	// Kubernetes version API is removed in
 	RemovedInVersion string `json:"removedInVersion,omitempty"`
+
+	// Policy overrides the policy of when the API is mapped, either "deprecated" or "removed"
+	Policy string `json:"policy,omitempty"`
+}
+
+// IsValidPolicy returns true if the policy is a known mapping policy
+func IsValidPolicy(policy string) bool {
+	return policy == PolicyDeprecated || policy == PolicyRemoved
+}
+
+// MappedInVersion returns the Kubernetes version from which the API is mapped, following
+// the policy of the mapping if it has one or else the policy passed. It returns an empty
+// string if the API is never mapped under the policy.
+func (m *Mapping) MappedInVersion(policy string) string {
+	if m.Policy != "" {
+		policy = m.Policy
+	}
+	if policy == PolicyRemoved {
+		return m.RemovedInVersion
+	}
+	if m.DeprecatedInVersion != "" {
+		return m.DeprecatedInVersion
+	}
+	return m.RemovedInVersion
+}
+
+// GroupVersionKind identifies a Kubernetes API kind
+type GroupVersionKind struct {
+	// Group is the API group, empty for the core group
+	Group string `json:"group,omitempty"`
+
+	// Version is the API version
+	Version string `json:"version"`
+
+	// Kind is the kind of resource
+	Kind string `json:"kind"`
+}
+
+// IsStructured returns true if the mapping describes its APIs with From and To
+func (m *Mapping) IsStructured() bool {
+	return m.From != nil || m.To != nil
+}
+
+// SourceAPI returns the API looking to be mapped as it is described in the mapping file
+func (m *Mapping) SourceAPI() string {
+	if m.IsStructured() {
+		return m.From.String()
+	}
+	return m.DeprecatedAPI
+}
+
+// TargetAPI returns the API to be mapped to as it is described in the mapping file
+func (m *Mapping) TargetAPI() string {
+	if m.IsStructured() {
+		return m.To.String()
+	}
+	return m.NewAPI
+}
+
+// APIVersion returns the apiVersion of the GroupVersionKind as it is set in a manifest
+func (g *GroupVersionKind) APIVersion() string {
+	if g.Group == "" {
+		return g.Version
+	}
+	return g.Group + "/" + g.Version
+}
+
+// String returns the GroupVersionKind as it is set in a manifest
+func (g *GroupVersionKind) String() string {
+	if g == nil {
+		return ""
+	}
+	return fmt.Sprintf("apiVersion: %s\nkind: %s", g.APIVersion(), g.Kind)
 }
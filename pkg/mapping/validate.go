@@ -0,0 +1,328 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mapping
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/semver"
+	yaml3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/yaml"
+)
+
+// Problem is an issue found in a mapping file
+type Problem struct {
+	// Line is the line of the mapping file the problem is on
+	Line int
+
+	// Message describes the problem
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// legacyAPIPattern matches a deprecatedAPI regex which only matches an apiVersion and kind
+var legacyAPIPattern = regexp.MustCompile(`^apiVersion: ([\w.\-/]+)\[\\s\]\+kind: (\w+)(?:\\n|\n)?$`)
+
+// legacyNewAPI matches a newAPI which only sets an apiVersion and kind
+var legacyNewAPI = regexp.MustCompile(`^apiVersion: ([\w.\-/]+)\nkind: (\w+)\n?$`)
+
+// ValidateMapfile checks every mapping of a Map.yaml file and returns all the problems found
+func ValidateMapfile(filename string) ([]Problem, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Validate(b)
+}
+
+// Validate checks every mapping of the content of a Map.yaml file and returns all the problems found.
+// An error is returned only if the content cannot be parsed.
+func Validate(data []byte) ([]Problem, error) {
+	metadata := new(Metadata)
+	if err := yaml.Unmarshal(data, metadata); err != nil {
+		return nil, err
+	}
+	var root yaml3.Node
+	if err := yaml3.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	rules := make([]*ruleInfo, len(metadata.Mappings))
+	items := mappingNodes(&root)
+	for i, m := range metadata.Mappings {
+		rules[i] = &ruleInfo{mapping: m}
+		if i < len(items) {
+			rules[i].node = items[i]
+		}
+	}
+
+	var problems []Problem
+	for _, r := range rules {
+		problems = append(problems, r.validate()...)
+	}
+	problems = append(problems, validateOverlaps(rules)...)
+	problems = append(problems, validateCycles(rules)...)
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	return problems, nil
+}
+
+// ruleInfo is a mapping with where it is in the mapping file and the APIs it maps, when they are known
+type ruleInfo struct {
+	mapping *Mapping
+	node    *yaml3.Node
+	re      *regexp.Regexp
+	from    *GroupVersionKind
+	to      *GroupVersionKind
+}
+
+func (r *ruleInfo) line(field ...string) int {
+	n := r.node
+	for _, key := range field {
+		v := fieldNode(n, key)
+		if v == nil {
+			break
+		}
+		n = v
+	}
+	if n == nil {
+		return 0
+	}
+	return n.Line
+}
+
+func (r *ruleInfo) problem(message string, field ...string) Problem {
+	return Problem{Line: r.line(field...), Message: message}
+}
+
+// version returns the Kubernetes version the mapping is applied from under the default policy
+func (r *ruleInfo) version() string {
+	return r.mapping.MappedInVersion(PolicyDeprecated)
+}
+
+func (r *ruleInfo) validate() []Problem {
+	var problems []Problem
+	m := r.mapping
+
+	switch {
+	case m.IsStructured() && (m.DeprecatedAPI != "" || m.NewAPI != ""):
+		problems = append(problems, r.problem("mapping sets both from/to and deprecatedAPI/newAPI"))
+	case m.IsStructured():
+		problems = append(problems, r.validateGVK("from", m.From)...)
+		problems = append(problems, r.validateGVK("to", m.To)...)
+		if m.From != nil && m.To != nil {
+			r.from, r.to = m.From, m.To
+		}
+	default:
+		if m.DeprecatedAPI == "" {
+			problems = append(problems, r.problem("deprecatedAPI is not set"))
+		} else if re, err := regexp.Compile(m.DeprecatedAPI); err != nil {
+			problems = append(problems, r.problem(fmt.Sprintf("deprecatedAPI is not a valid regex: %s", err), "deprecatedAPI"))
+		} else {
+			r.re = re
+		}
+		if m.NewAPI == "" {
+			problems = append(problems, r.problem("newAPI is not set"))
+		}
+		r.from = parseLegacyAPI(legacyAPIPattern, m.DeprecatedAPI)
+		r.to = parseLegacyAPI(legacyNewAPI, m.NewAPI)
+	}
+
+	deprecated, removed := m.DeprecatedInVersion, m.RemovedInVersion
+	if deprecated == "" && removed == "" {
+		problems = append(problems, r.problem("neither deprecatedInVersion nor removedInVersion is set"))
+	}
+	if deprecated != "" && !semver.IsValid(deprecated) {
+		problems = append(problems, r.problem(fmt.Sprintf("deprecatedInVersion '%s' is not a valid version", deprecated), "deprecatedInVersion"))
+	}
+	if removed != "" && !semver.IsValid(removed) {
+		problems = append(problems, r.problem(fmt.Sprintf("removedInVersion '%s' is not a valid version", removed), "removedInVersion"))
+	}
+	if m.Policy != "" && !IsValidPolicy(m.Policy) {
+		problems = append(problems, r.problem(fmt.Sprintf("policy '%s' is not one of '%s' or '%s'", m.Policy, PolicyDeprecated, PolicyRemoved), "policy"))
+	}
+	if semver.IsValid(deprecated) && semver.IsValid(removed) && semver.Compare(deprecated, removed) > 0 {
+		problems = append(problems, r.problem(fmt.Sprintf("deprecatedInVersion '%s' is later than removedInVersion '%s'", deprecated, removed), "deprecatedInVersion"))
+	}
+	return problems
+}
+
+func (r *ruleInfo) validateGVK(field string, gvk *GroupVersionKind) []Problem {
+	if gvk == nil {
+		return []Problem{r.problem(fmt.Sprintf("%s is not set", field))}
+	}
+	var problems []Problem
+	if gvk.Version == "" {
+		problems = append(problems, r.problem(fmt.Sprintf("%s.version is not set", field), field))
+	}
+	if gvk.Kind == "" {
+		problems = append(problems, r.problem(fmt.Sprintf("%s.kind is not set", field), field))
+	}
+	return problems
+}
+
+// matches returns true if the rule maps resources of the API
+func (r *ruleInfo) matches(gvk *GroupVersionKind) bool {
+	if r.re != nil {
+		return r.re.MatchString(gvk.String() + "\n")
+	}
+	return r.from != nil && *r.from == *gvk
+}
+
+// validateOverlaps finds rules which duplicate an earlier rule, or which never
+// apply as an earlier rule maps the same API first
+func validateOverlaps(rules []*ruleInfo) []Problem {
+	var problems []Problem
+	for j, later := range rules {
+		for _, earlier := range rules[:j] {
+			if !overlaps(earlier, later) {
+				continue
+			}
+			if sameTarget(earlier, later) {
+				problems = append(problems, later.problem(fmt.Sprintf("mapping is a duplicate of the mapping on line %d", earlier.line())))
+				break
+			}
+			if semver.IsValid(earlier.version()) && semver.IsValid(later.version()) &&
+				semver.Compare(earlier.version(), later.version()) <= 0 {
+				problems = append(problems, later.problem(fmt.Sprintf("mapping is shadowed by the mapping on line %d which maps the same API first", earlier.line())))
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// overlaps returns true if the later rule only maps resources which the earlier rule maps too
+func overlaps(earlier, later *ruleInfo) bool {
+	if later.from != nil {
+		return earlier.matches(later.from)
+	}
+	return earlier.re != nil && later.re != nil && earlier.mapping.DeprecatedAPI == later.mapping.DeprecatedAPI
+}
+
+func sameTarget(a, b *ruleInfo) bool {
+	if a.to != nil && b.to != nil {
+		return *a.to == *b.to
+	}
+	return a.mapping.TargetAPI() == b.mapping.TargetAPI()
+}
+
+// validateCycles finds rules which map an API back to itself, directly or through other rules
+func validateCycles(rules []*ruleInfo) []Problem {
+	var problems []Problem
+	reported := make(map[string]bool)
+	for i, r := range rules {
+		if r.from == nil || r.to == nil {
+			continue
+		}
+		cycle := findCycle(rules, i)
+		if cycle == nil {
+			continue
+		}
+
+		key := make([]int, len(cycle))
+		copy(key, cycle)
+		sort.Ints(key)
+		if reported[fmt.Sprint(key)] {
+			continue
+		}
+		reported[fmt.Sprint(key)] = true
+
+		if len(cycle) == 1 {
+			problems = append(problems, r.problem(fmt.Sprintf("mapping maps %s/%s to itself", r.from.APIVersion(), r.from.Kind)))
+			continue
+		}
+		var path, lines []string
+		for _, c := range cycle {
+			path = append(path, rules[c].from.APIVersion()+"/"+rules[c].from.Kind)
+			lines = append(lines, fmt.Sprint(rules[c].line()))
+		}
+		path = append(path, r.from.APIVersion()+"/"+r.from.Kind)
+		problems = append(problems, r.problem(fmt.Sprintf("mappings on lines %s form a cycle: %s",
+			strings.Join(lines, ", "), strings.Join(path, " -> "))))
+	}
+	return problems
+}
+
+// findCycle returns the indexes of the rules which map the API mapped by rule start back to it
+func findCycle(rules []*ruleInfo, start int) []int {
+	target := *rules[start].from
+	visited := make(map[GroupVersionKind]bool)
+	var walk func(path []int) []int
+	walk = func(path []int) []int {
+		to := *rules[path[len(path)-1]].to
+		if to == target {
+			return path
+		}
+		if visited[to] {
+			return nil
+		}
+		visited[to] = true
+		for j, next := range rules {
+			if next.from != nil && next.to != nil && *next.from == to {
+				if cycle := walk(append(path[:len(path):len(path)], j)); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		return nil
+	}
+	return walk([]int{start})
+}
+
+func parseLegacyAPI(re *regexp.Regexp, api string) *GroupVersionKind {
+	match := re.FindStringSubmatch(api)
+	if match == nil {
+		return nil
+	}
+	gvk := &GroupVersionKind{Version: match[1], Kind: match[2]}
+	if i := strings.LastIndex(match[1], "/"); i >= 0 {
+		gvk.Group, gvk.Version = match[1][:i], match[1][i+1:]
+	}
+	return gvk
+}
+
+// mappingNodes returns the nodes of the mappings in a parsed mapping file
+func mappingNodes(root *yaml3.Node) []*yaml3.Node {
+	if root.Kind != yaml3.DocumentNode || len(root.Content) == 0 {
+		return nil
+	}
+	mappings := fieldNode(root.Content[0], "mappings")
+	if mappings == nil || mappings.Kind != yaml3.SequenceNode {
+		return nil
+	}
+	return mappings.Content
+}
+
+func fieldNode(n *yaml3.Node, key string) *yaml3.Node {
+	if n == nil || n.Kind != yaml3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
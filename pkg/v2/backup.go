@@ -0,0 +1,76 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+// GetStorageRef returns the reference to the storage objects of the release in the options.
+// The storage objects are in the Tiller namespace.
+func GetStorageRef(mapOptions common.MapOptions) (common.StorageRef, error) {
+	ref := common.StorageRef{
+		HelmVersion: "v2",
+		Release:     mapOptions.ReleaseName,
+		Namespace:   mapOptions.ReleaseNamespace,
+	}
+	switch storageType := getStorageType(mapOptions); storageType {
+	case "configmap", "configmaps", "":
+		ref.StorageType = common.StorageConfigMaps
+	case "secret", "secrets":
+		ref.StorageType = common.StorageSecrets
+	default:
+		return ref, errors.Errorf("unsupported release storage type: %s", storageType)
+	}
+	return ref, nil
+}
+
+// backupRelease saves the storage object of the release version before it is superseded by the new
+// version, and the storage objects of the release versions to delete. It returns the path of the backup file.
+func backupRelease(rel *release.Release, toDelete []*release.Release, newVersion int32, mapOptions common.MapOptions) (string, error) {
+	return backupReleaseVersions(append([]*release.Release{rel}, toDelete...), []int32{newVersion}, mapOptions)
+}
+
+// backupReleaseVersions saves the storage objects of the release versions which are about to be updated or
+// deleted, and the versions which are about to be created. It returns the path of the backup file.
+func backupReleaseVersions(toUpdate []*release.Release, newVersions []int32, mapOptions common.MapOptions) (string, error) {
+	rel := toUpdate[0]
+	mapOptions.ReleaseName = rel.Name
+	ref, err := GetStorageRef(mapOptions)
+	if err != nil {
+		return "", err
+	}
+	var updated, created []string
+	for _, r := range toUpdate {
+		updated = append(updated, storageObjectName(r.Name, r.Version))
+	}
+	for _, version := range newVersions {
+		created = append(created, storageObjectName(rel.Name, version))
+	}
+	return common.BackupRelease(mapOptions, ref, updated, created)
+}
+
+// storageObjectName returns the name of the Secret or ConfigMap which stores a release version
+func storageObjectName(name string, version int32) string {
+	return fmt.Sprintf("%s.v%d", name, version)
+}
@@ -0,0 +1,88 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"log"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/helm/pkg/proto/hapi/release"
+	storageerrors "k8s.io/helm/pkg/storage/errors"
+
+	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+// ListReleases returns the releases stored by Tiller in the namespace of the options which are
+// selected by the filter, sorted by name. Tiller stores the releases of all namespaces in its own namespace.
+func ListReleases(mapOptions common.MapOptions, filter common.ReleaseFilter) ([]common.ReleaseRef, error) {
+	selector, err := filter.SelectorLabels()
+	if err != nil {
+		return nil, err
+	}
+	storageDriver, err := GetStorageDriver(mapOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Tiller storage")
+	}
+
+	var releases []*release.Release
+	if len(selector) > 0 {
+		selector["OWNER"] = "TILLER"
+		releases, err = storageDriver.Query(selector)
+		if err != nil && err.Error() == storageerrors.ErrReleaseNotFound(selector["NAME"]).Error() {
+			err = nil
+		}
+	} else {
+		releases, err = storageDriver.ListReleases()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list releases")
+	}
+
+	// the storage returns every version of a release
+	var found []string
+	latest := make(map[string]*release.Release)
+	for _, rel := range releases {
+		if last, ok := latest[rel.Name]; !ok {
+			found = append(found, rel.Name)
+		} else if last.Version > rel.Version {
+			continue
+		}
+		latest[rel.Name] = rel
+	}
+	var refs []common.ReleaseRef
+	for _, name := range found {
+		rel := latest[name]
+		if isUninstalled(rel.Info.Status.Code) {
+			log.Printf("Skip release '%s', as its latest revision %d is '%s'.\n", rel.Name, rel.Version, rel.Info.Status.Code)
+			continue
+		}
+		if filter.Matches(rel.Name, getChartName(rel)) {
+			refs = append(refs, common.ReleaseRef{Name: rel.Name, Namespace: mapOptions.ReleaseNamespace})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, nil
+}
+
+func getChartName(rel *release.Release) string {
+	if rel.Chart == nil || rel.Chart.Metadata == nil {
+		return ""
+	}
+	return rel.Chart.Metadata.Name
+}
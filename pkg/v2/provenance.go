@@ -0,0 +1,34 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+// recordProvenance adds the provenance labels and annotations to the storage object of the
+// release version mapped, so that it can be selected by them
+func recordProvenance(rel *release.Release, version int32, mapOptions common.MapOptions, provenance *common.Provenance) error {
+	mapOptions.ReleaseName = rel.Name
+	ref, err := GetStorageRef(mapOptions)
+	if err != nil {
+		return err
+	}
+	return common.RecordProvenance(mapOptions, ref, storageObjectName(rel.Name, version), provenance)
+}
@@ -21,6 +21,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 
 	"k8s.io/helm/pkg/proto/hapi/release"
@@ -30,72 +31,200 @@ import (
 	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
 )
 
-// MapReleaseWithUnSupportedAPIs checks the latest release version for any deprecated or removed APIs in its metadata
-// If it finds any, it will create a new release version with the APIs mapped to the supported versions
-func MapReleaseWithUnSupportedAPIs(mapOptions common.MapOptions) error {
-	var releaseName = mapOptions.ReleaseName
-	log.Printf("Get release '%s' latest version.\n", releaseName)
+// CheckReleaseForUnSupportedAPIs checks the latest release version for any deprecated or removed APIs in its metadata
+// without updating the release. It returns a report of the resources found using those APIs.
+func CheckReleaseForUnSupportedAPIs(mapOptions common.MapOptions) (*common.ReleaseReport, error) {
 	storageDriver, err := GetStorageDriver(mapOptions)
 	if err != nil {
-		return errors.Wrapf(err, "Failed to get release '%s' latest version", mapOptions.ReleaseName)
+		return nil, errors.Wrapf(err, "Failed to get release '%s' latest version", mapOptions.ReleaseName)
 	}
-	releaseToMap, err := getLatestRelease(releaseName, storageDriver)
+
+	_, _, _, report, err := checkRelease(mapOptions, storageDriver)
+	return report, err
+}
+
+// MapReleaseWithUnSupportedAPIs checks the latest release version for any deprecated or removed APIs in its metadata
+// If it finds any, it will create a new release version with the APIs mapped to the supported versions.
+// It returns a report of the resources found using those APIs. The release version is only superseded if
+// it was not changed since it was read, and the release is read and mapped again if it was, up to the
+// number of conflict retries.
+func MapReleaseWithUnSupportedAPIs(mapOptions common.MapOptions) (*common.ReleaseReport, error) {
+	var report *common.ReleaseReport
+	err := common.RetryOnConflict(mapOptions.ConflictRetries, func() error {
+		var err error
+		report, err = mapRelease(mapOptions)
+		return err
+	})
+	return report, err
+}
+
+// mapRelease maps the latest release version, with its storage objects guarded against changes of other clients
+func mapRelease(mapOptions common.MapOptions) (*common.ReleaseReport, error) {
+	guard := common.NewStorageGuard()
+	storageDriver, err := getGuardedStorageDriver(mapOptions, guard)
 	if err != nil {
-		return errors.Wrapf(err, "Failed to get release '%s' latest version", mapOptions.ReleaseName)
+		return nil, errors.Wrapf(err, "Failed to get release '%s' latest version", mapOptions.ReleaseName)
 	}
 
-	log.Printf("Check release '%s' for deprecated or removed APIs...\n", releaseName)
-	var origManifest = releaseToMap.Manifest
-	modifiedManifest, err := common.ReplaceManifestUnSupportedAPIs(origManifest, mapOptions.MapFile, mapOptions.KubeConfig)
+	var releaseName = mapOptions.ReleaseName
+	releaseToMap, later, modifiedManifest, report, err := checkRelease(mapOptions, storageDriver)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if releaseToMap == nil {
+		return report, nil
 	}
-	log.Printf("Finished checking release '%s' for deprecated or removed APIs.\n", releaseName)
-	if modifiedManifest == origManifest {
+	if modifiedManifest == releaseToMap.Manifest {
 		log.Printf("Release '%s' has no deprecated or removed APIs.\n", releaseName)
-		return nil
+		return report, nil
 	}
 
 	log.Printf("Deprecated or removed APIs exist, updating release: %s.\n", releaseName)
 	if !mapOptions.DryRun {
-		if err := updateRelease(releaseToMap, modifiedManifest, storageDriver); err != nil {
-			return errors.Wrapf(err, "Failed to update release '%s'", releaseName)
+		if err := common.VerifyServedAPIs(mapOptions, modifiedManifest); err != nil {
+			return nil, errors.Wrapf(err, "Refusing to update release '%s'", releaseName)
+		}
+		provenance, err := common.NewProvenance(mapOptions, report)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get the provenance of release '%s'", releaseName)
+		}
+		description, err := provenance.Describe(common.UpgradeDescription)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to describe the provenance of release '%s'", releaseName)
+		}
+
+		// the release versions after the release version mapped are failed or pending, and are
+		// either deleted or left as they are
+		var deleted []*release.Release
+		if mapOptions.FailedRevision == common.FailedRevisionDelete {
+			deleted, later = later, nil
+		}
+		newVersion := nextVersion(releaseToMap, later)
+
+		backupFile, err := backupRelease(releaseToMap, deleted, newVersion, mapOptions)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to back up release '%s'", releaseName)
+		}
+		if backupFile != "" {
+			log.Printf("Release '%s' backed up to: %s\n", releaseName, backupFile)
+		}
+		if err := updateRelease(releaseToMap, deleted, newVersion, modifiedManifest, description, storageDriver); err != nil {
+			if guard.Conflicted() {
+				common.DiscardBackup(backupFile)
+				err = &common.ConflictError{Release: releaseName, Err: err}
+			}
+			return nil, errors.Wrapf(err, "Failed to update release '%s'", releaseName)
+		}
+		// the release is updated, so a failure to record the provenance does not fail the map
+		if err := recordProvenance(releaseToMap, newVersion, mapOptions, provenance); err != nil {
+			log.Printf("WARNING: Failed to record the provenance of updated release '%s': %s\n", releaseName, err)
 		}
 		log.Printf("Release '%s' with deprecated or removed APIs updated successfully to new version.\n", releaseName)
 	}
 
-	return nil
+	return report, nil
 }
 
-func getLatestRelease(releaseName string, storageDriver *storage.Storage) (*release.Release, error) {
-	return storageDriver.Last(releaseName)
+// checkRelease gets the release version to map and returns it with the failed or pending release versions
+// after it, if any, its manifest mapped to supported APIs and a report of the resources found using deprecated
+// or removed APIs. No release version is returned for an uninstalled release, with an empty report.
+func checkRelease(mapOptions common.MapOptions, storageDriver *storage.Storage) (*release.Release, []*release.Release, string, *common.ReleaseReport, error) {
+	var releaseName = mapOptions.ReleaseName
+	log.Printf("Get release '%s' latest version.\n", releaseName)
+	releaseToMap, later, err := getReleaseToMap(mapOptions, storageDriver)
+	if err != nil {
+		return nil, nil, "", nil, errors.Wrapf(err, "Failed to get release '%s' latest version", mapOptions.ReleaseName)
+	}
+	if releaseToMap == nil {
+		return nil, nil, "", &common.ReleaseReport{Release: releaseName, Namespace: mapOptions.ReleaseNamespace, Resources: []common.Finding{}}, nil
+	}
+
+	modifiedManifest, report, err := mapManifest(releaseName, releaseToMap, mapOptions)
+	if err != nil {
+		return nil, nil, "", nil, err
+	}
+	return releaseToMap, later, modifiedManifest, report, nil
 }
 
-func updateRelease(origRelease *release.Release, modifiedManifest string, storageDriver *storage.Storage) error {
+// mapManifest returns the manifest of the release version mapped to supported APIs and a report of the resources
+// found using deprecated or removed APIs. The name is the name of the release, or release version, to log.
+func mapManifest(name string, rel *release.Release, mapOptions common.MapOptions) (string, *common.ReleaseReport, error) {
+	log.Printf("Check release '%s' for deprecated or removed APIs...\n", name)
+	modifiedManifest, findings, err := common.ReplaceManifestUnSupportedAPIs(rel.Manifest, mapOptions)
+	if err != nil {
+		return "", nil, err
+	}
+	log.Printf("Finished checking release '%s' for deprecated or removed APIs.\n", name)
+	report := &common.ReleaseReport{
+		Release:   rel.Name,
+		Namespace: rel.Namespace,
+		Revision:  int(rel.Version),
+		Resources: findings,
+		Diffs:     common.DiffManifests(rel.Manifest, modifiedManifest),
+	}
+	if mapOptions.DryRun && len(report.Diffs) > 0 {
+		log.Printf("Changes to the manifest of release '%s':\n%s", name, common.FormatDiffs(report.Diffs, mapOptions.Color))
+	}
+	return modifiedManifest, report, nil
+}
+
+// updateRelease deletes the release versions to delete, supersedes the release version and adds a new version
+// with the modified manifest and description. If the new version fails to be added, the status of the release
+// version is restored. If the release fails to be updated, the deleted release versions are recreated.
+func updateRelease(origRelease *release.Release, toDelete []*release.Release, newVersion int32, modifiedManifest, description string, storageDriver *storage.Storage) error {
+	origStatus := origRelease.Info.Status.Code
+
+	deleted, err := deleteReleaseVersions(toDelete, storageDriver)
+	if err != nil {
+		return recreateReleaseVersions(deleted, storageDriver, err)
+	}
+
+	// Using a deep copy of current release version to update the object with the modification
+	// and then store this new version, so that the current release version is left as is
+	newRelease := proto.Clone(origRelease).(*release.Release)
+	newRelease.Manifest = modifiedManifest
+	newRelease.Info.Description = description
+	newRelease.Info.LastDeployed = timeconv.Timestamp(time.Now())
+	newRelease.Version = newVersion
+	newRelease.Info.Status.Code = release.Status_DEPLOYED
+
 	// Update current release version to be superseded
 	log.Printf("Set status of release version '%s' to 'superseded'.\n", getReleaseVersionName(origRelease))
 	origRelease.Info.Status.Code = release.Status_SUPERSEDED
 	if err := storageDriver.Update(origRelease); err != nil {
-		return errors.Wrapf(err, "failed to update release version '%s'", getReleaseVersionName(origRelease))
+		updateErr := errors.Wrapf(err, "failed to update release version '%s'", getReleaseVersionName(origRelease))
+		return recreateReleaseVersions(deleted, storageDriver, updateErr)
 	}
 	log.Printf("Release version '%s' updated successfully.\n", getReleaseVersionName(origRelease))
 
-	// Using a shallow copy of  current release version to update the object with the modification
-	// and then store this new version
-	var newRelease = origRelease
-	newRelease.Manifest = modifiedManifest
-	newRelease.Info.Description = common.UpgradeDescription
-	newRelease.Info.LastDeployed = timeconv.Timestamp(time.Now())
-	newRelease.Version = origRelease.Version + 1
-	newRelease.Info.Status.Code = release.Status_DEPLOYED
-	log.Printf("Add release version '%s' with updated supported APIs.\n", getReleaseVersionName(origRelease))
+	log.Printf("Add release version '%s' with updated supported APIs.\n", getReleaseVersionName(newRelease))
 	if err := storageDriver.Create(newRelease); err != nil {
-		return errors.Wrapf(err, "failed to create new release version '%s'", getReleaseVersionName(origRelease))
+		createErr := errors.Wrapf(err, "failed to create new release version '%s'", getReleaseVersionName(newRelease))
+		restoreErr := restoreReleaseStatus(origRelease.Name, origRelease.Version, origStatus, storageDriver, createErr)
+		return recreateReleaseVersions(deleted, storageDriver, restoreErr)
 	}
-	log.Printf("Release version '%s' added successfully.\n", getReleaseVersionName(origRelease))
+	log.Printf("Release version '%s' added successfully.\n", getReleaseVersionName(newRelease))
 	return nil
 }
 
+// restoreReleaseStatus sets the status of the stored release version back to the status it had before it was
+// superseded. It returns the error which caused the restore wrapped with the result of the restore, so that the
+// cause can still be inspected.
+func restoreReleaseStatus(name string, version int32, status release.Status_Code, storageDriver *storage.Storage, cause error) error {
+	versionName := fmt.Sprintf("%s.v%d", name, version)
+	log.Printf("Restore status of release version '%s' to '%s'.\n", versionName, status)
+	rel, err := storageDriver.Get(name, version)
+	if err == nil {
+		rel.Info.Status.Code = status
+		err = storageDriver.Update(rel)
+	}
+	if err != nil {
+		return errors.Wrapf(cause, "failed to restore status of release version '%s' to '%s' (%s)", versionName, status, err)
+	}
+	log.Printf("Status of release version '%s' restored successfully.\n", versionName)
+	return errors.Wrapf(cause, "status of release version '%s' was restored to '%s'", versionName, status)
+}
+
 func getReleaseVersionName(rel *release.Release) string {
 	return fmt.Sprintf("%s.v%d", rel.Name, rel.Version)
 }
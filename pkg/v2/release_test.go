@@ -0,0 +1,230 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/storage"
+	"k8s.io/helm/pkg/storage/driver"
+	"k8s.io/helm/pkg/timeconv"
+
+	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+const (
+	origManifest = `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+`
+	mappedManifest = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+`
+)
+
+var deployedAt = time.Date(2020, 4, 17, 13, 5, 45, 0, time.UTC)
+
+// newTestStorage returns a storage of ConfigMaps in a fake clientset, with the release versions stored
+func newTestStorage(t *testing.T, rels ...*release.Release) (*storage.Storage, *fake.Clientset) {
+	clientSet := fake.NewSimpleClientset()
+	storageDriver := storage.Init(driver.NewConfigMaps(clientSet.CoreV1().ConfigMaps("kube-system")))
+	for _, rel := range rels {
+		if err := storageDriver.Create(rel); err != nil {
+			t.Fatalf("failed to store release version '%s': %s", getReleaseVersionName(rel), err)
+		}
+	}
+	return storageDriver, clientSet
+}
+
+func newTestRelease(version int32, status release.Status_Code) *release.Release {
+	return &release.Release{
+		Name:      "myrel",
+		Namespace: "default",
+		Version:   version,
+		Manifest:  origManifest,
+		Info: &release.Info{
+			Status:        &release.Status{Code: status},
+			FirstDeployed: timeconv.Timestamp(deployedAt),
+			LastDeployed:  timeconv.Timestamp(deployedAt),
+			Description:   "Upgrade complete",
+		},
+		Hooks: []*release.Hook{{Name: "pre-upgrade", Events: []release.Hook_Event{release.Hook_PRE_UPGRADE}}},
+	}
+}
+
+func TestUpdateReleaseRestoresStatusWhenCreateFails(t *testing.T) {
+	storageDriver, clientSet := newTestStorage(t, newTestRelease(1, release.Status_DEPLOYED))
+	createErr := errors.New("create failed")
+	clientSet.PrependReactor("create", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, createErr
+	})
+
+	rel, err := storageDriver.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = updateRelease(rel, nil, 2, mappedManifest, common.UpgradeDescription, storageDriver)
+	if err == nil {
+		t.Fatal("expected an error when the new release version fails to be created")
+	}
+	if errors.Cause(err) != createErr {
+		t.Errorf("expected the error to wrap the create error, got: %s", err)
+	}
+
+	stored, err := storageDriver.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Info.Status.Code != release.Status_DEPLOYED {
+		t.Errorf("expected status of release version 1 to be restored to '%s', got '%s'", release.Status_DEPLOYED, stored.Info.Status.Code)
+	}
+	if _, err := storageDriver.Get("myrel", 2); err == nil {
+		t.Error("expected release version 2 not to be stored")
+	}
+}
+
+func TestUpdateReleaseKeepsSupersededReleaseVersion(t *testing.T) {
+	// the memory driver stores a copy of the release versions, and returns the copy it caches
+	storageDriver := storage.Init(driver.NewMemory())
+	if err := storageDriver.Create(newTestRelease(1, release.Status_DEPLOYED)); err != nil {
+		t.Fatal(err)
+	}
+
+	rel, err := storageDriver.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateRelease(rel, nil, 2, mappedManifest, common.UpgradeDescription, storageDriver); err != nil {
+		t.Fatal(err)
+	}
+
+	superseded, err := storageDriver.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*release.Release{superseded, rel} {
+		if r.Manifest != origManifest {
+			t.Errorf("expected release version 1 to keep its manifest, got:\n%s", r.Manifest)
+		}
+		if r.Info.Status.Code != release.Status_SUPERSEDED {
+			t.Errorf("expected status of release version 1 to be '%s', got '%s'", release.Status_SUPERSEDED, r.Info.Status.Code)
+		}
+		if r.Info.Description != "Upgrade complete" {
+			t.Errorf("expected release version 1 to keep its description, got '%s'", r.Info.Description)
+		}
+		if !proto.Equal(r.Info.FirstDeployed, timeconv.Timestamp(deployedAt)) || !proto.Equal(r.Info.LastDeployed, timeconv.Timestamp(deployedAt)) {
+			t.Errorf("expected release version 1 to keep its timestamps, got first deployed %s and last deployed %s", r.Info.FirstDeployed, r.Info.LastDeployed)
+		}
+		if len(r.Hooks) != 1 || len(r.Hooks[0].Events) != 1 || r.Hooks[0].Events[0] != release.Hook_PRE_UPGRADE {
+			t.Errorf("expected release version 1 to keep its hooks, got %v", r.Hooks)
+		}
+	}
+
+	mapped, err := storageDriver.Get("myrel", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapped.Manifest != mappedManifest {
+		t.Errorf("expected release version 2 to have the mapped manifest, got:\n%s", mapped.Manifest)
+	}
+	if mapped.Info.Status.Code != release.Status_DEPLOYED {
+		t.Errorf("expected status of release version 2 to be '%s', got '%s'", release.Status_DEPLOYED, mapped.Info.Status.Code)
+	}
+	if mapped.Info.Description != common.UpgradeDescription {
+		t.Errorf("expected release version 2 to have the upgrade description, got '%s'", mapped.Info.Description)
+	}
+}
+
+func TestUpdateReleaseRecreatesDeletedReleaseVersionsWhenCreateFails(t *testing.T) {
+	failed := newTestRelease(2, release.Status_FAILED)
+	storageDriver, clientSet := newTestStorage(t, newTestRelease(1, release.Status_DEPLOYED), failed)
+	createErr := errors.New("create failed")
+	createFailed := false
+	// only the create of the new release version fails
+	clientSet.PrependReactor("create", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if createFailed {
+			return false, nil, nil
+		}
+		createFailed = true
+		return true, nil, createErr
+	})
+
+	rel, err := storageDriver.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = updateRelease(rel, []*release.Release{failed}, 2, mappedManifest, common.UpgradeDescription, storageDriver)
+	if err == nil {
+		t.Fatal("expected an error when the new release version fails to be created")
+	}
+	if errors.Cause(err) != createErr {
+		t.Errorf("expected the error to wrap the create error, got: %s", err)
+	}
+
+	stored, err := storageDriver.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Info.Status.Code != release.Status_DEPLOYED {
+		t.Errorf("expected status of release version 1 to be restored to '%s', got '%s'", release.Status_DEPLOYED, stored.Info.Status.Code)
+	}
+	recreated, err := storageDriver.Get("myrel", 2)
+	if err != nil {
+		t.Fatalf("expected deleted release version 2 to be recreated: %s", err)
+	}
+	if recreated.Info.Status.Code != release.Status_FAILED || recreated.Manifest != origManifest {
+		t.Errorf("expected release version 2 to be recreated as it was, got status '%s' and manifest:\n%s", recreated.Info.Status.Code, recreated.Manifest)
+	}
+}
+
+func TestRestoreReleaseHistory(t *testing.T) {
+	storageDriver, _ := newTestStorage(t, newTestRelease(1, release.Status_SUPERSEDED))
+
+	rel, err := storageDriver.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mappedRelease{release: rel, manifest: mappedManifest, provenance: &common.Provenance{}}
+	if err := updateReleaseInPlace(m.release, m.manifest, m.provenance, storageDriver); err != nil {
+		t.Fatal(err)
+	}
+	updateErr := errors.New("update failed")
+	if err := restoreReleaseHistory([]mappedRelease{m}, storageDriver, updateErr); errors.Cause(err) != updateErr {
+		t.Errorf("expected the error to wrap the update error, got: %s", err)
+	}
+
+	restored, err := storageDriver.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Manifest != origManifest || restored.Info.Description != "Upgrade complete" {
+		t.Errorf("expected release version 1 to be restored, got description '%s' and manifest:\n%s", restored.Info.Description, restored.Manifest)
+	}
+}
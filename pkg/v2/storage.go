@@ -32,6 +32,12 @@ import (
 
 // GetStorageDriver return handle to Helm v2 backend storage driver
 func GetStorageDriver(mapOptions common.MapOptions) (*storage.Storage, error) {
+	return getGuardedStorageDriver(mapOptions, nil)
+}
+
+// getGuardedStorageDriver returns handle to Helm v2 backend storage driver which only updates the
+// storage objects if they were not changed since they were read. A nil guard does not check them.
+func getGuardedStorageDriver(mapOptions common.MapOptions, guard *common.StorageGuard) (*storage.Storage, error) {
 	clientSet := utils.GetClientSetWithKubeConfig(mapOptions.KubeConfig.File, mapOptions.KubeConfig.Context)
 	if clientSet == nil {
 		return nil, errors.Errorf("kubernetes cluster unreachable")
@@ -41,11 +47,11 @@ func GetStorageDriver(mapOptions common.MapOptions) (*storage.Storage, error) {
 
 	switch storageType {
 	case "configmap", "configmaps", "":
-		cfgMaps := driver.NewConfigMaps(clientSet.CoreV1().ConfigMaps(namespace))
+		cfgMaps := driver.NewConfigMaps(guard.ConfigMaps(clientSet.CoreV1().ConfigMaps(namespace)))
 		cfgMaps.Log = newLogger("storage/driver").Printf
 		return storage.Init(cfgMaps), nil
 	case "secret", "secrets":
-		secrets := driver.NewSecrets(clientSet.CoreV1().Secrets(namespace))
+		secrets := driver.NewSecrets(guard.Secrets(clientSet.CoreV1().Secrets(namespace)))
 		secrets.Log = newLogger("storage/driver").Printf
 		return storage.Init(secrets), nil
 	default:
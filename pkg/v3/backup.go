@@ -0,0 +1,94 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/release"
+
+	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+// GetStorageRef returns the reference to the storage objects of the release in the options
+func GetStorageRef(mapOptions common.MapOptions) (common.StorageRef, error) {
+	ref := common.StorageRef{
+		HelmVersion: "v3",
+		Release:     mapOptions.ReleaseName,
+		Namespace:   getNamespace(mapOptions.ReleaseNamespace, mapOptions.KubeConfig),
+	}
+	switch driver := os.Getenv("HELM_DRIVER"); driver {
+	case "secret", "secrets", "":
+		ref.StorageType = common.StorageSecrets
+	case "configmap", "configmaps":
+		ref.StorageType = common.StorageConfigMaps
+	default:
+		return ref, errors.Errorf("unsupported release storage type: %s", driver)
+	}
+	return ref, nil
+}
+
+// backupRelease saves the storage object of the release version before it is superseded by the new
+// version, and the storage objects of the release versions to delete. It returns the path of the backup file.
+func backupRelease(rel *release.Release, toDelete []*release.Release, newVersion int, mapOptions common.MapOptions) (string, error) {
+	return backupReleaseVersions(append([]*release.Release{rel}, toDelete...), []int{newVersion}, mapOptions)
+}
+
+// backupReleaseVersions saves the storage objects of the release versions which are about to be updated or
+// deleted, and the versions which are about to be created. It returns the path of the backup file.
+func backupReleaseVersions(toUpdate []*release.Release, newVersions []int, mapOptions common.MapOptions) (string, error) {
+	rel := toUpdate[0]
+	if os.Getenv("HELM_DRIVER") == "memory" {
+		log.Printf("Release '%s' is stored in memory, skipping backup.\n", rel.Name)
+		return "", nil
+	}
+	mapOptions.ReleaseName = rel.Name
+	mapOptions.ReleaseNamespace = rel.Namespace
+	ref, err := GetStorageRef(mapOptions)
+	if err != nil {
+		return "", err
+	}
+	var updated, created []string
+	for _, r := range toUpdate {
+		updated = append(updated, storageObjectName(r.Name, r.Version))
+	}
+	for _, version := range newVersions {
+		created = append(created, storageObjectName(rel.Name, version))
+	}
+	return common.BackupRelease(mapOptions, ref, updated, created)
+}
+
+// storageObjectName returns the name of the Secret or ConfigMap which stores a release version
+func storageObjectName(name string, version int) string {
+	return fmt.Sprintf("sh.helm.release.v1.%s.v%d", name, version)
+}
+
+// getNamespace returns the namespace passed, or the namespace of the kubeconfig context if none is passed
+func getNamespace(namespace string, kubeConfig common.KubeConfig) string {
+	// Add kube config settings passed by user
+	settings.KubeConfig = kubeConfig.File
+	settings.KubeContext = kubeConfig.Context
+
+	if namespace == "" {
+		namespace = settings.Namespace()
+	}
+	return namespace
+}
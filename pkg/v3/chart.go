@@ -0,0 +1,155 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/releaseutil"
+
+	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+// notesFileSuffix is the suffix of the chart notes template, which is not a manifest
+const notesFileSuffix = "NOTES.txt"
+
+// CheckChart renders the chart at the path with the Helm v3 engine for the Kubernetes version and the
+// values, and checks the rendered manifest for deprecated or removed APIs. The chart is not installed and
+// the cluster is not accessed. It returns a report of the resources found, with the template of each.
+func CheckChart(chartPath string, vals map[string]interface{}, mapOptions common.MapOptions) (*common.ReleaseReport, error) {
+	log.Printf("Render chart: %s\n", chartPath)
+	manifest, err := renderChart(chartPath, vals, mapOptions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to render chart '%s'", chartPath)
+	}
+
+	log.Printf("Check chart '%s' for deprecated or removed APIs...\n", chartPath)
+	modifiedManifest, findings, err := common.ReplaceManifestUnSupportedAPIs(manifest, mapOptions)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Finished checking chart '%s' for deprecated or removed APIs.\n", chartPath)
+	report := &common.ReleaseReport{
+		Chart:     chartPath,
+		Release:   mapOptions.ReleaseName,
+		Namespace: mapOptions.ReleaseNamespace,
+		Resources: findings,
+		Diffs:     common.DiffManifests(manifest, modifiedManifest),
+	}
+	if len(report.Diffs) > 0 {
+		log.Printf("Changes needed to the templates of chart '%s':\n%s", chartPath, common.FormatDiffs(report.Diffs, mapOptions.Color))
+	}
+	return report, nil
+}
+
+// renderChart renders the chart as helm template does, and returns the manifest of its CRDs and
+// templates. Each resource has the Source comment with the path of the file it is from.
+func renderChart(chartPath string, vals map[string]interface{}, mapOptions common.MapOptions) (string, error) {
+	chrt, err := loader.Load(chartPath)
+	if err != nil {
+		return "", err
+	}
+	if err := checkChart(chrt); err != nil {
+		return "", err
+	}
+	if req := chrt.Metadata.Dependencies; req != nil {
+		if err := action.CheckDependencies(chrt, req); err != nil {
+			return "", err
+		}
+	}
+	if err := chartutil.ProcessDependencies(chrt, vals); err != nil {
+		return "", err
+	}
+
+	options := chartutil.ReleaseOptions{
+		Name:      mapOptions.ReleaseName,
+		Namespace: mapOptions.ReleaseNamespace,
+		Revision:  1,
+		IsInstall: true,
+	}
+	values, err := chartutil.ToRenderValues(chrt, vals, options, chartCapabilities(mapOptions.KubeVersion))
+	if err != nil {
+		return "", err
+	}
+	rendered, err := engine.Render(chrt, values)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, crd := range chrt.CRDObjects() {
+		writeManifestFile(&sb, crd.Filename, string(crd.File.Data))
+	}
+	files := make([]string, 0, len(rendered))
+	for file := range rendered {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		if strings.HasSuffix(file, notesFileSuffix) {
+			continue
+		}
+		writeManifestFile(&sb, file, rendered[file])
+	}
+	return sb.String(), nil
+}
+
+// checkChart returns an error if the chart cannot be installed
+func checkChart(chrt *chart.Chart) error {
+	switch chrt.Metadata.Type {
+	case "", "application":
+		return nil
+	}
+	return errors.Errorf("chart type '%s' cannot be rendered, only application charts can", chrt.Metadata.Type)
+}
+
+// chartCapabilities returns the default capabilities of the Helm v3 engine for the Kubernetes version
+func chartCapabilities(kubeVersion string) *chartutil.Capabilities {
+	caps := *chartutil.DefaultCapabilities
+	majorMinor := strings.TrimPrefix(semver.MajorMinor(kubeVersion), "v")
+	major := strings.SplitN(majorMinor, ".", 2)[0]
+	caps.KubeVersion = chartutil.KubeVersion{
+		Version: kubeVersion,
+		Major:   major,
+		Minor:   strings.TrimPrefix(majorMinor, major+"."),
+	}
+	return &caps
+}
+
+// writeManifestFile writes each resource of a rendered file with the Source comment of the file
+func writeManifestFile(sb *strings.Builder, file, content string) {
+	docs := releaseutil.SplitManifests(content)
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+	for _, key := range keys {
+		fmt.Fprintf(sb, "---\n# Source: %s\n%s\n", file, docs[key])
+	}
+}
@@ -23,6 +23,8 @@ import (
 
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
 
 	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
 )
@@ -33,6 +35,12 @@ var (
 
 // GetActionConfig returns action configuration based on Helm env
 func GetActionConfig(namespace string, kubeConfig common.KubeConfig) (*action.Configuration, error) {
+	return getActionConfig(namespace, false, kubeConfig)
+}
+
+// getActionConfig returns action configuration based on Helm env. When allNamespaces
+// is set, the release storage covers all namespaces instead of the namespace passed.
+func getActionConfig(namespace string, allNamespaces bool, kubeConfig common.KubeConfig) (*action.Configuration, error) {
 	actionConfig := new(action.Configuration)
 
 	// Add kube config settings passed by user
@@ -40,7 +48,9 @@ func GetActionConfig(namespace string, kubeConfig common.KubeConfig) (*action.Co
 	settings.KubeContext = kubeConfig.Context
 
 	// check if the namespace is passed by the user. If not get Helm to return the current namespace
-	if namespace == "" {
+	if allNamespaces {
+		namespace = ""
+	} else if namespace == "" {
 		namespace = settings.Namespace()
 	}
 
@@ -52,6 +62,34 @@ func GetActionConfig(namespace string, kubeConfig common.KubeConfig) (*action.Co
 	return actionConfig, err
 }
 
+// getGuardedActionConfig returns action configuration based on Helm env whose release storage
+// only updates the storage objects if they were not changed since they were read
+func getGuardedActionConfig(namespace string, kubeConfig common.KubeConfig, guard *common.StorageGuard) (*action.Configuration, error) {
+	actionConfig, err := GetActionConfig(namespace, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := actionConfig.KubernetesClientSet()
+	if err != nil {
+		return nil, err
+	}
+
+	namespace = getNamespace(namespace, kubeConfig)
+	switch os.Getenv("HELM_DRIVER") {
+	case "secret", "secrets", "":
+		d := driver.NewSecrets(guard.Secrets(clientset.CoreV1().Secrets(namespace)))
+		d.Log = debug
+		actionConfig.Releases = storage.Init(d)
+	case "configmap", "configmaps":
+		d := driver.NewConfigMaps(guard.ConfigMaps(clientset.CoreV1().ConfigMaps(namespace)))
+		d.Log = debug
+		actionConfig.Releases = storage.Init(d)
+	}
+	// the memory driver is not shared with other clients, so it is left as is
+
+	return actionConfig, nil
+}
+
 func debug(format string, v ...interface{}) {
 	if settings.Debug {
 		format = fmt.Sprintf("[debug] %s\n", format)
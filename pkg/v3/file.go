@@ -0,0 +1,65 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/release"
+
+	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+// MapReleaseData maps the manifest of the release in the release data of a storage object, without
+// connecting to a cluster. It returns the release data with the mapped release, which is the release
+// data passed if the release has no deprecated or removed APIs, and a report of the resources found.
+func MapReleaseData(data string, mapOptions common.MapOptions) (string, *common.ReleaseReport, error) {
+	b, err := common.DecodeReleaseData(data)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to decode release data")
+	}
+	rel := new(release.Release)
+	if err := json.Unmarshal(b, rel); err != nil {
+		return "", nil, errors.Wrap(err, "failed to decode release")
+	}
+
+	modifiedManifest, report, err := mapManifest(getReleaseVersionName(rel), rel, mapOptions)
+	if err != nil {
+		return "", nil, err
+	}
+	if modifiedManifest == rel.Manifest {
+		return data, report, nil
+	}
+
+	provenance, err := common.NewProvenance(mapOptions, report)
+	if err != nil {
+		return "", nil, err
+	}
+	rel.Manifest = modifiedManifest
+	if rel.Info.Description, err = provenance.Describe(rel.Info.Description); err != nil {
+		return "", nil, errors.Wrap(err, "failed to describe the provenance of release")
+	}
+	if b, err = json.Marshal(rel); err != nil {
+		return "", nil, errors.Wrap(err, "failed to encode release")
+	}
+	if data, err = common.EncodeReleaseData(b); err != nil {
+		return "", nil, errors.Wrap(err, "failed to encode release data")
+	}
+	return data, report, nil
+}
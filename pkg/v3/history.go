@@ -0,0 +1,208 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+
+	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+// mappedRelease is a release version with its manifest mapped to supported APIs
+type mappedRelease struct {
+	release    *release.Release
+	manifest   string
+	report     *common.ReleaseReport
+	provenance *common.Provenance
+}
+
+// CheckReleaseHistory checks every stored release version for any deprecated or removed APIs in its metadata
+// without updating the release. It returns a report for each release version.
+func CheckReleaseHistory(mapOptions common.MapOptions) ([]common.ReleaseReport, error) {
+	cfg, err := GetActionConfig(mapOptions.ReleaseNamespace, mapOptions.KubeConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
+	}
+
+	_, reports, err := checkReleaseHistory(mapOptions, cfg)
+	return reports, err
+}
+
+// MapReleaseHistory checks every stored release version for any deprecated or removed APIs in its metadata.
+// It updates the manifest of the release versions which have any in place, keeping their versions and statuses,
+// so that the release can be rolled back to any of them. It returns a report for each release version.
+func MapReleaseHistory(mapOptions common.MapOptions) ([]common.ReleaseReport, error) {
+	var reports []common.ReleaseReport
+	err := common.RetryOnConflict(mapOptions.ConflictRetries, func() error {
+		var err error
+		reports, err = mapReleaseHistory(mapOptions)
+		return err
+	})
+	return reports, err
+}
+
+// mapReleaseHistory maps every release version, with its storage objects guarded against changes of other clients
+func mapReleaseHistory(mapOptions common.MapOptions) ([]common.ReleaseReport, error) {
+	guard := common.NewStorageGuard()
+	cfg, err := getGuardedActionConfig(mapOptions.ReleaseNamespace, mapOptions.KubeConfig, guard)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
+	}
+
+	var releaseName = mapOptions.ReleaseName
+	mapped, reports, err := checkReleaseHistory(mapOptions, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(mapped) == 0 {
+		log.Printf("Release '%s' has no deprecated or removed APIs in any release version.\n", releaseName)
+		return reports, nil
+	}
+
+	log.Printf("Deprecated or removed APIs exist in %d release version(s), updating release: %s.\n", len(mapped), releaseName)
+	if !mapOptions.DryRun {
+		manifests := make([]string, len(mapped))
+		for i, m := range mapped {
+			manifests[i] = m.manifest
+		}
+		if err := common.VerifyServedAPIs(mapOptions, manifests...); err != nil {
+			return nil, errors.Wrapf(err, "refusing to update release '%s'", releaseName)
+		}
+
+		for i, m := range mapped {
+			if mapped[i].provenance, err = common.NewProvenance(mapOptions, m.report); err != nil {
+				return nil, errors.Wrapf(err, "failed to get the provenance of release version '%s'", getReleaseVersionName(m.release))
+			}
+		}
+
+		backupFile, err := backupReleaseHistory(mapped, mapOptions)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to back up release '%s'", releaseName)
+		}
+		if backupFile != "" {
+			log.Printf("Release '%s' backed up to: %s\n", releaseName, backupFile)
+		}
+		for i, m := range mapped {
+			if err := updateReleaseInPlace(m.release, m.manifest, m.provenance, cfg); err != nil {
+				err = restoreReleaseHistory(mapped[:i], cfg, err)
+				if guard.Conflicted() {
+					// the backup is only needed if any release version was updated
+					if i == 0 {
+						common.DiscardBackup(backupFile)
+					}
+					err = &common.ConflictError{Release: releaseName, Err: err}
+				}
+				return nil, errors.Wrapf(err, "failed to update release '%s'", releaseName)
+			}
+		}
+		for _, m := range mapped {
+			// the release versions are updated, so a failure to record the provenance does not fail the map
+			if err := recordProvenance(m.release, m.release.Version, mapOptions, m.provenance); err != nil {
+				log.Printf("WARNING: Failed to record the provenance of updated release version '%s': %s\n", getReleaseVersionName(m.release), err)
+			}
+		}
+		log.Printf("Release '%s' with deprecated or removed APIs updated successfully in all release versions.\n", releaseName)
+	}
+
+	return reports, nil
+}
+
+// checkReleaseHistory gets every release version and returns the ones whose manifest has deprecated or removed
+// APIs with their manifest mapped to supported APIs, and a report for each release version
+func checkReleaseHistory(mapOptions common.MapOptions, cfg *action.Configuration) ([]mappedRelease, []common.ReleaseReport, error) {
+	var releaseName = mapOptions.ReleaseName
+	log.Printf("Get release '%s' versions.\n", releaseName)
+	history, err := cfg.Releases.History(releaseName)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to get release '%s' versions", releaseName)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Version < history[j].Version })
+
+	var mapped []mappedRelease
+	reports := []common.ReleaseReport{}
+	for _, rel := range history {
+		modifiedManifest, report, err := mapManifest(getReleaseVersionName(rel), rel, mapOptions)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to check release version '%s'", getReleaseVersionName(rel))
+		}
+		reports = append(reports, *report)
+		if modifiedManifest != rel.Manifest {
+			mapped = append(mapped, mappedRelease{release: rel, manifest: modifiedManifest, report: report})
+		}
+	}
+	return mapped, reports, nil
+}
+
+// updateReleaseInPlace updates the release version with the modified manifest, keeping its version and status.
+// The provenance is added to its description.
+func updateReleaseInPlace(origRelease *release.Release, modifiedManifest string, provenance *common.Provenance, cfg *action.Configuration) error {
+	newRelease := copyRelease(origRelease)
+	newRelease.Manifest = modifiedManifest
+	description, err := provenance.Describe(newRelease.Info.Description)
+	if err != nil {
+		return errors.Wrapf(err, "failed to describe the provenance of release version '%s'", getReleaseVersionName(newRelease))
+	}
+	newRelease.Info.Description = description
+
+	log.Printf("Update release version '%s' with updated supported APIs.\n", getReleaseVersionName(newRelease))
+	if err := cfg.Releases.Update(newRelease); err != nil {
+		return errors.Wrapf(err, "failed to update release version '%s'", getReleaseVersionName(newRelease))
+	}
+	log.Printf("Release version '%s' updated successfully.\n", getReleaseVersionName(newRelease))
+	return nil
+}
+
+// restoreReleaseHistory updates the release versions back to how they were before they were mapped, after
+// a later release version failed to be updated. It returns the error which caused it wrapped with the release
+// versions restored, or the ones left mapped which can be restored from the backup of the release.
+func restoreReleaseHistory(mapped []mappedRelease, cfg *action.Configuration, cause error) error {
+	if len(mapped) == 0 {
+		return cause
+	}
+	var restored, failed []string
+	for _, m := range mapped {
+		log.Printf("Restore release version '%s'.\n", getReleaseVersionName(m.release))
+		if err := cfg.Releases.Update(m.release); err != nil {
+			log.Printf("Failed to restore release version '%s': %s\n", getReleaseVersionName(m.release), err)
+			failed = append(failed, getReleaseVersionName(m.release))
+			continue
+		}
+		restored = append(restored, getReleaseVersionName(m.release))
+	}
+	if len(failed) > 0 {
+		return errors.Wrapf(cause, "failed to restore updated release versions '%s', restore them from the backup of the release",
+			strings.Join(failed, "', '"))
+	}
+	return errors.Wrapf(cause, "updated release versions '%s' were restored", strings.Join(restored, "', '"))
+}
+
+// backupReleaseHistory saves the storage objects of the release versions before they are updated.
+// It returns the path of the backup file.
+func backupReleaseHistory(mapped []mappedRelease, mapOptions common.MapOptions) (string, error) {
+	toUpdate := make([]*release.Release, len(mapped))
+	for i, m := range mapped {
+		toUpdate[i] = m.release
+	}
+	return backupReleaseVersions(toUpdate, nil, mapOptions)
+}
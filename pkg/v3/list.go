@@ -0,0 +1,96 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"log"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+// ListReleases returns the releases stored in the namespace of the options, or in all
+// namespaces when allNamespaces is set, which are selected by the filter.
+// The releases are sorted by namespace and name.
+func ListReleases(mapOptions common.MapOptions, allNamespaces bool, filter common.ReleaseFilter) ([]common.ReleaseRef, error) {
+	selector, err := filter.SelectorLabels()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := getActionConfig(mapOptions.ReleaseNamespace, allNamespaces, mapOptions.KubeConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
+	}
+
+	var releases []*release.Release
+	if len(selector) > 0 {
+		// only the storage objects of Helm are releases, as Helm's List selects them
+		selector["owner"] = "helm"
+		releases, err = cfg.Releases.Query(selector)
+		if err == driver.ErrReleaseNotFound {
+			err = nil
+		}
+	} else {
+		releases, err = cfg.Releases.ListReleases()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list releases")
+	}
+
+	// the storage returns every version of a release
+	var found []common.ReleaseRef
+	latest := make(map[common.ReleaseRef]*release.Release)
+	for _, rel := range releases {
+		ref := common.ReleaseRef{Name: rel.Name, Namespace: rel.Namespace}
+		if last, ok := latest[ref]; !ok {
+			found = append(found, ref)
+		} else if last.Version > rel.Version {
+			continue
+		}
+		latest[ref] = rel
+	}
+	var refs []common.ReleaseRef
+	for _, ref := range found {
+		rel := latest[ref]
+		if isUninstalled(rel.Info.Status) {
+			log.Printf("Skip release '%s' in namespace '%s', as its latest revision %d is '%s'.\n", rel.Name, rel.Namespace, rel.Version, rel.Info.Status)
+			continue
+		}
+		if filter.Matches(rel.Name, getChartName(rel)) {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Namespace != refs[j].Namespace {
+			return refs[i].Namespace < refs[j].Namespace
+		}
+		return refs[i].Name < refs[j].Name
+	})
+	return refs, nil
+}
+
+func getChartName(rel *release.Release) string {
+	if rel.Chart == nil || rel.Chart.Metadata == nil {
+		return ""
+	}
+	return rel.Chart.Metadata.Name
+}
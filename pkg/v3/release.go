@@ -28,71 +28,218 @@ import (
 	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
 )
 
-// MapReleaseWithUnSupportedAPIs checks the latest release version for any deprecated or removed APIs in its metadata
-// If it finds any, it will create a new release version with the APIs mapped to the supported versions
-func MapReleaseWithUnSupportedAPIs(mapOptions common.MapOptions) error {
+// CheckReleaseForUnSupportedAPIs checks the latest release version for any deprecated or removed APIs in its metadata
+// without updating the release. It returns a report of the resources found using those APIs.
+func CheckReleaseForUnSupportedAPIs(mapOptions common.MapOptions) (*common.ReleaseReport, error) {
 	cfg, err := GetActionConfig(mapOptions.ReleaseNamespace, mapOptions.KubeConfig)
 	if err != nil {
-		return errors.Wrap(err, "failed to get Helm action configuration")
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
 	}
 
-	var releaseName = mapOptions.ReleaseName
-	log.Printf("Get release '%s' latest version.\n", releaseName)
-	releaseToMap, err := getLatestRelease(releaseName, cfg)
+	_, _, _, report, err := checkRelease(mapOptions, cfg)
+	return report, err
+}
+
+// MapReleaseWithUnSupportedAPIs checks the latest release version for any deprecated or removed APIs in its metadata
+// If it finds any, it will create a new release version with the APIs mapped to the supported versions.
+// It returns a report of the resources found using those APIs. The release version is only superseded if
+// it was not changed since it was read, and the release is read and mapped again if it was, up to the
+// number of conflict retries.
+func MapReleaseWithUnSupportedAPIs(mapOptions common.MapOptions) (*common.ReleaseReport, error) {
+	var report *common.ReleaseReport
+	err := common.RetryOnConflict(mapOptions.ConflictRetries, func() error {
+		var err error
+		report, err = mapRelease(mapOptions)
+		return err
+	})
+	return report, err
+}
+
+// mapRelease maps the latest release version, with its storage objects guarded against changes of other clients
+func mapRelease(mapOptions common.MapOptions) (*common.ReleaseReport, error) {
+	guard := common.NewStorageGuard()
+	cfg, err := getGuardedActionConfig(mapOptions.ReleaseNamespace, mapOptions.KubeConfig, guard)
 	if err != nil {
-		return errors.Wrapf(err, "failed to get release '%s' latest version", mapOptions.ReleaseName)
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
 	}
 
-	log.Printf("Check release '%s' for deprecated or removed APIs...\n", releaseName)
-	var origManifest = releaseToMap.Manifest
-	modifiedManifest, err := common.ReplaceManifestUnSupportedAPIs(origManifest, mapOptions.MapFile, mapOptions.KubeConfig)
+	var releaseName = mapOptions.ReleaseName
+	releaseToMap, later, modifiedManifest, report, err := checkRelease(mapOptions, cfg)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if releaseToMap == nil {
+		return report, nil
 	}
-	log.Printf("Finished checking release '%s' for deprecated or removed APIs.\n", releaseName)
-	if modifiedManifest == origManifest {
+	if modifiedManifest == releaseToMap.Manifest {
 		log.Printf("Release '%s' has no deprecated or removed APIs.\n", releaseName)
-		return nil
+		return report, nil
 	}
 
 	log.Printf("Deprecated or removed APIs exist, updating release: %s.\n", releaseName)
 	if !mapOptions.DryRun {
-		if err := updateRelease(releaseToMap, modifiedManifest, cfg); err != nil {
-			return errors.Wrapf(err, "failed to update release '%s'", releaseName)
+		if err := common.VerifyServedAPIs(mapOptions, modifiedManifest); err != nil {
+			return nil, errors.Wrapf(err, "refusing to update release '%s'", releaseName)
+		}
+		provenance, err := common.NewProvenance(mapOptions, report)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get the provenance of release '%s'", releaseName)
+		}
+		description, err := provenance.Describe(common.UpgradeDescription)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to describe the provenance of release '%s'", releaseName)
+		}
+
+		// the release versions after the release version mapped are failed or pending, and are
+		// either deleted or left as they are
+		var deleted []*release.Release
+		if mapOptions.FailedRevision == common.FailedRevisionDelete {
+			deleted, later = later, nil
+		}
+		newVersion := nextVersion(releaseToMap, later)
+
+		backupFile, err := backupRelease(releaseToMap, deleted, newVersion, mapOptions)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to back up release '%s'", releaseName)
+		}
+		if backupFile != "" {
+			log.Printf("Release '%s' backed up to: %s\n", releaseName, backupFile)
+		}
+		if err := updateRelease(releaseToMap, deleted, newVersion, modifiedManifest, description, cfg); err != nil {
+			if guard.Conflicted() {
+				common.DiscardBackup(backupFile)
+				err = &common.ConflictError{Release: releaseName, Err: err}
+			}
+			return nil, errors.Wrapf(err, "failed to update release '%s'", releaseName)
+		}
+		// the release is updated, so a failure to record the provenance does not fail the map
+		if err := recordProvenance(releaseToMap, newVersion, mapOptions, provenance); err != nil {
+			log.Printf("WARNING: Failed to record the provenance of updated release '%s': %s\n", releaseName, err)
 		}
 		log.Printf("Release '%s' with deprecated or removed APIs updated successfully to new version.\n", releaseName)
 	}
 
-	return nil
+	return report, nil
 }
 
-func updateRelease(origRelease *release.Release, modifiedManifest string, cfg *action.Configuration) error {
+// checkRelease gets the release version to map and returns it with the failed or pending release versions
+// after it, if any, its manifest mapped to supported APIs and a report of the resources found using deprecated
+// or removed APIs. No release version is returned for an uninstalled release, with an empty report.
+func checkRelease(mapOptions common.MapOptions, cfg *action.Configuration) (*release.Release, []*release.Release, string, *common.ReleaseReport, error) {
+	var releaseName = mapOptions.ReleaseName
+	log.Printf("Get release '%s' latest version.\n", releaseName)
+	releaseToMap, later, err := getReleaseToMap(mapOptions, cfg)
+	if err != nil {
+		return nil, nil, "", nil, errors.Wrapf(err, "failed to get release '%s' latest version", mapOptions.ReleaseName)
+	}
+	if releaseToMap == nil {
+		return nil, nil, "", &common.ReleaseReport{Release: releaseName, Namespace: mapOptions.ReleaseNamespace, Resources: []common.Finding{}}, nil
+	}
+
+	modifiedManifest, report, err := mapManifest(releaseName, releaseToMap, mapOptions)
+	if err != nil {
+		return nil, nil, "", nil, err
+	}
+	return releaseToMap, later, modifiedManifest, report, nil
+}
+
+// mapManifest returns the manifest of the release version mapped to supported APIs and a report of the resources
+// found using deprecated or removed APIs. The name is the name of the release, or release version, to log.
+func mapManifest(name string, rel *release.Release, mapOptions common.MapOptions) (string, *common.ReleaseReport, error) {
+	log.Printf("Check release '%s' for deprecated or removed APIs...\n", name)
+	modifiedManifest, findings, err := common.ReplaceManifestUnSupportedAPIs(rel.Manifest, mapOptions)
+	if err != nil {
+		return "", nil, err
+	}
+	log.Printf("Finished checking release '%s' for deprecated or removed APIs.\n", name)
+	report := &common.ReleaseReport{
+		Release:   rel.Name,
+		Namespace: rel.Namespace,
+		Revision:  rel.Version,
+		Resources: findings,
+		Diffs:     common.DiffManifests(rel.Manifest, modifiedManifest),
+	}
+	if mapOptions.DryRun && len(report.Diffs) > 0 {
+		log.Printf("Changes to the manifest of release '%s':\n%s", name, common.FormatDiffs(report.Diffs, mapOptions.Color))
+	}
+	return modifiedManifest, report, nil
+}
+
+// updateRelease deletes the release versions to delete, supersedes the release version and adds a new version
+// with the modified manifest and description. If the new version fails to be added, the status of the release
+// version is restored. If the release fails to be updated, the deleted release versions are recreated.
+func updateRelease(origRelease *release.Release, toDelete []*release.Release, newVersion int, modifiedManifest, description string, cfg *action.Configuration) error {
+	origStatus := origRelease.Info.Status
+
+	deleted, err := deleteReleaseVersions(toDelete, cfg)
+	if err != nil {
+		return recreateReleaseVersions(deleted, cfg, err)
+	}
+
+	// Using a copy of current release version to update the object with the modification
+	// and then store this new version, so that the current release version is left as is
+	newRelease := copyRelease(origRelease)
+	newRelease.Manifest = modifiedManifest
+	newRelease.Info.Description = description
+	newRelease.Info.LastDeployed = cfg.Now()
+	newRelease.Version = newVersion
+	newRelease.Info.Status = release.StatusDeployed
+
 	// Update current release version to be superseded
 	log.Printf("Set status of release version '%s' to 'superseded'.\n", getReleaseVersionName(origRelease))
 	origRelease.Info.Status = release.StatusSuperseded
 	if err := cfg.Releases.Update(origRelease); err != nil {
-		return errors.Wrapf(err, "failed to update release version '%s': %s", getReleaseVersionName(origRelease))
+		updateErr := errors.Wrapf(err, "failed to update release version '%s'", getReleaseVersionName(origRelease))
+		return recreateReleaseVersions(deleted, cfg, updateErr)
 	}
 	log.Printf("Release version '%s' updated successfully.\n", getReleaseVersionName(origRelease))
 
-	// Using a shallow copy of current release version to update the object with the modification
-	// and then store this new version
-	var newRelease = origRelease
-	newRelease.Manifest = modifiedManifest
-	newRelease.Info.Description = common.UpgradeDescription
-	newRelease.Info.LastDeployed = cfg.Now()
-	newRelease.Version = origRelease.Version + 1
-	newRelease.Info.Status = release.StatusDeployed
-	log.Printf("Add release version '%s' with updated supported APIs.\n", getReleaseVersionName(origRelease))
+	log.Printf("Add release version '%s' with updated supported APIs.\n", getReleaseVersionName(newRelease))
 	if err := cfg.Releases.Create(newRelease); err != nil {
-		return errors.Wrapf(err, "failed to create new release version '%s': %s", getReleaseVersionName(origRelease))
+		createErr := errors.Wrapf(err, "failed to create new release version '%s'", getReleaseVersionName(newRelease))
+		restoreErr := restoreReleaseStatus(origRelease.Name, origRelease.Version, origStatus, cfg, createErr)
+		return recreateReleaseVersions(deleted, cfg, restoreErr)
 	}
-	log.Printf("Release version '%s' added successfully.\n", getReleaseVersionName(origRelease))
+	log.Printf("Release version '%s' added successfully.\n", getReleaseVersionName(newRelease))
 	return nil
 }
 
-func getLatestRelease(releaseName string, cfg *action.Configuration) (*release.Release, error) {
-	return cfg.Releases.Last(releaseName)
+// copyRelease returns a copy of the release whose info and hooks can be modified without changing the release.
+// The chart and config are shared with the release, as they are never modified.
+func copyRelease(rel *release.Release) *release.Release {
+	newRelease := *rel
+	if rel.Info != nil {
+		info := *rel.Info
+		newRelease.Info = &info
+	}
+	if rel.Hooks != nil {
+		newRelease.Hooks = make([]*release.Hook, len(rel.Hooks))
+		for i, hook := range rel.Hooks {
+			h := *hook
+			h.Events = append([]release.HookEvent(nil), hook.Events...)
+			h.DeletePolicies = append([]release.HookDeletePolicy(nil), hook.DeletePolicies...)
+			newRelease.Hooks[i] = &h
+		}
+	}
+	return &newRelease
+}
+
+// restoreReleaseStatus sets the status of the stored release version back to the status it had before it was
+// superseded. It returns the error which caused the restore wrapped with the result of the restore, so that the
+// cause can still be inspected.
+func restoreReleaseStatus(name string, version int, status release.Status, cfg *action.Configuration, cause error) error {
+	versionName := fmt.Sprintf("%s.v%d", name, version)
+	log.Printf("Restore status of release version '%s' to '%s'.\n", versionName, status)
+	rel, err := cfg.Releases.Get(name, version)
+	if err == nil {
+		rel.Info.Status = status
+		err = cfg.Releases.Update(rel)
+	}
+	if err != nil {
+		return errors.Wrapf(cause, "failed to restore status of release version '%s' to '%s' (%s)", versionName, status, err)
+	}
+	log.Printf("Status of release version '%s' restored successfully.\n", versionName)
+	return errors.Wrapf(cause, "status of release version '%s' was restored to '%s'", versionName, status)
 }
 
 func getReleaseVersionName(rel *release.Release) string {
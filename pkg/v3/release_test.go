@@ -0,0 +1,231 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	helmtime "helm.sh/helm/v3/pkg/time"
+
+	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+const (
+	origManifest = `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+`
+	mappedManifest = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+`
+)
+
+var deployedAt = helmtime.Time{Time: time.Date(2020, 4, 17, 13, 5, 45, 0, time.UTC)}
+
+// failingDriver is a memory driver whose next create fails with the error set
+type failingDriver struct {
+	*driver.Memory
+	createErr error
+}
+
+func (d *failingDriver) Create(key string, rls *release.Release) error {
+	if err := d.createErr; err != nil {
+		d.createErr = nil
+		return err
+	}
+	return d.Memory.Create(key, rls)
+}
+
+// newTestConfig returns an action configuration whose release storage is the driver, with the release versions stored
+func newTestConfig(t *testing.T, d *failingDriver, rels ...*release.Release) *action.Configuration {
+	for _, rel := range rels {
+		if err := d.Memory.Create(storageObjectName(rel.Name, rel.Version), rel); err != nil {
+			t.Fatalf("failed to store release version '%s': %s", getReleaseVersionName(rel), err)
+		}
+	}
+	return &action.Configuration{Releases: storage.Init(d)}
+}
+
+func newTestRelease(version int, status release.Status) *release.Release {
+	return &release.Release{
+		Name:      "myrel",
+		Namespace: "default",
+		Version:   version,
+		Manifest:  origManifest,
+		Info: &release.Info{
+			FirstDeployed: deployedAt,
+			LastDeployed:  deployedAt,
+			Description:   "Upgrade complete",
+			Status:        status,
+		},
+		Hooks: []*release.Hook{{Name: "pre-upgrade", Events: []release.HookEvent{release.HookPreUpgrade}}},
+	}
+}
+
+func TestUpdateReleaseRestoresStatusWhenCreateFails(t *testing.T) {
+	createErr := errors.New("create failed")
+	d := &failingDriver{Memory: driver.NewMemory(), createErr: createErr}
+	cfg := newTestConfig(t, d, newTestRelease(1, release.StatusDeployed))
+
+	rel, err := cfg.Releases.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = updateRelease(rel, nil, 2, mappedManifest, common.UpgradeDescription, cfg)
+	if err == nil {
+		t.Fatal("expected an error when the new release version fails to be created")
+	}
+	if errors.Cause(err) != createErr {
+		t.Errorf("expected the error to wrap the create error, got: %s", err)
+	}
+
+	stored, err := cfg.Releases.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Info.Status != release.StatusDeployed {
+		t.Errorf("expected status of release version 1 to be restored to '%s', got '%s'", release.StatusDeployed, stored.Info.Status)
+	}
+	if _, err := cfg.Releases.Get("myrel", 2); err == nil {
+		t.Error("expected release version 2 not to be stored")
+	}
+}
+
+func TestUpdateReleaseKeepsSupersededReleaseVersion(t *testing.T) {
+	d := &failingDriver{Memory: driver.NewMemory()}
+	// the memory driver caches the release versions stored, so orig is the object the storage returns
+	orig := newTestRelease(1, release.StatusDeployed)
+	cfg := newTestConfig(t, d, orig)
+
+	rel, err := cfg.Releases.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateRelease(rel, nil, 2, mappedManifest, common.UpgradeDescription, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	superseded, err := cfg.Releases.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*release.Release{superseded, orig} {
+		if r.Manifest != origManifest {
+			t.Errorf("expected release version 1 to keep its manifest, got:\n%s", r.Manifest)
+		}
+		if r.Info.Status != release.StatusSuperseded {
+			t.Errorf("expected status of release version 1 to be '%s', got '%s'", release.StatusSuperseded, r.Info.Status)
+		}
+		if r.Info.Description != "Upgrade complete" {
+			t.Errorf("expected release version 1 to keep its description, got '%s'", r.Info.Description)
+		}
+		if !r.Info.FirstDeployed.Equal(deployedAt) || !r.Info.LastDeployed.Equal(deployedAt) {
+			t.Errorf("expected release version 1 to keep its timestamps, got first deployed %s and last deployed %s", r.Info.FirstDeployed, r.Info.LastDeployed)
+		}
+		if len(r.Hooks) != 1 || len(r.Hooks[0].Events) != 1 || r.Hooks[0].Events[0] != release.HookPreUpgrade {
+			t.Errorf("expected release version 1 to keep its hooks, got %v", r.Hooks)
+		}
+	}
+
+	mapped, err := cfg.Releases.Get("myrel", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapped.Manifest != mappedManifest {
+		t.Errorf("expected release version 2 to have the mapped manifest, got:\n%s", mapped.Manifest)
+	}
+	if mapped.Info.Status != release.StatusDeployed {
+		t.Errorf("expected status of release version 2 to be '%s', got '%s'", release.StatusDeployed, mapped.Info.Status)
+	}
+	if mapped.Info.Description != common.UpgradeDescription {
+		t.Errorf("expected release version 2 to have the upgrade description, got '%s'", mapped.Info.Description)
+	}
+	if mapped.Info == orig.Info || mapped.Hooks[0] == orig.Hooks[0] {
+		t.Error("expected release version 2 not to share its info or hooks with release version 1")
+	}
+}
+
+func TestUpdateReleaseRecreatesDeletedReleaseVersionsWhenCreateFails(t *testing.T) {
+	createErr := errors.New("create failed")
+	d := &failingDriver{Memory: driver.NewMemory(), createErr: createErr}
+	failed := newTestRelease(2, release.StatusFailed)
+	cfg := newTestConfig(t, d, newTestRelease(1, release.StatusDeployed), failed)
+
+	rel, err := cfg.Releases.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = updateRelease(rel, []*release.Release{failed}, 2, mappedManifest, common.UpgradeDescription, cfg)
+	if err == nil {
+		t.Fatal("expected an error when the new release version fails to be created")
+	}
+	if errors.Cause(err) != createErr {
+		t.Errorf("expected the error to wrap the create error, got: %s", err)
+	}
+
+	stored, err := cfg.Releases.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Info.Status != release.StatusDeployed {
+		t.Errorf("expected status of release version 1 to be restored to '%s', got '%s'", release.StatusDeployed, stored.Info.Status)
+	}
+	recreated, err := cfg.Releases.Get("myrel", 2)
+	if err != nil {
+		t.Fatalf("expected deleted release version 2 to be recreated: %s", err)
+	}
+	if recreated.Info.Status != release.StatusFailed || recreated.Manifest != origManifest {
+		t.Errorf("expected release version 2 to be recreated as it was, got status '%s' and manifest:\n%s", recreated.Info.Status, recreated.Manifest)
+	}
+}
+
+func TestRestoreReleaseHistory(t *testing.T) {
+	cfg := newTestConfig(t, &failingDriver{Memory: driver.NewMemory()}, newTestRelease(1, release.StatusSuperseded))
+
+	rel, err := cfg.Releases.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mappedRelease{release: rel, manifest: mappedManifest, provenance: &common.Provenance{}}
+	if err := updateReleaseInPlace(m.release, m.manifest, m.provenance, cfg); err != nil {
+		t.Fatal(err)
+	}
+	updateErr := errors.New("update failed")
+	if err := restoreReleaseHistory([]mappedRelease{m}, cfg, updateErr); errors.Cause(err) != updateErr {
+		t.Errorf("expected the error to wrap the update error, got: %s", err)
+	}
+
+	restored, err := cfg.Releases.Get("myrel", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Manifest != origManifest || restored.Info.Description != "Upgrade complete" {
+		t.Errorf("expected release version 1 to be restored, got description '%s' and manifest:\n%s", restored.Info.Description, restored.Manifest)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	common "github.com/hickeyma/helm-mapkubeapis/pkg/common"
+)
+
+// getReleaseToMap returns the release version to map, which is the latest release version unless it is
+// failed or pending. Then, depending on the options, it is the last deployed release version, which is
+// returned with the release versions after it. No release version is returned if the release is uninstalled,
+// as mapping it would install the release again.
+func getReleaseToMap(mapOptions common.MapOptions, cfg *action.Configuration) (*release.Release, []*release.Release, error) {
+	releaseName := mapOptions.ReleaseName
+	history, err := cfg.Releases.History(releaseName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(history) == 0 {
+		return nil, nil, driver.ErrReleaseNotFound
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Version < history[j].Version })
+
+	latest := history[len(history)-1]
+	if isUninstalled(latest.Info.Status) {
+		log.Printf("Latest revision %d of release '%s' is '%s', skipping the uninstalled release.\n", latest.Version, releaseName, latest.Info.Status)
+		return nil, nil, nil
+	}
+	if !isFailedOrPending(latest.Info.Status) {
+		return latest, nil, nil
+	}
+	if mapOptions.FailedRevision != common.FailedRevisionDeployed && mapOptions.FailedRevision != common.FailedRevisionDelete {
+		return nil, nil, common.RefuseFailedRevision(releaseName, latest.Version, latest.Info.Status.String())
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Info.Status == release.StatusDeployed {
+			log.Printf("Latest revision %d of release '%s' is '%s', mapping the last deployed revision %d.\n",
+				latest.Version, releaseName, latest.Info.Status, history[i].Version)
+			return history[i], history[i+1:], nil
+		}
+	}
+	return nil, nil, errors.Errorf("latest revision %d of release '%s' is '%s' and it has no deployed revision to map",
+		latest.Version, releaseName, latest.Info.Status)
+}
+
+// isFailedOrPending returns whether a release version failed or is pending, so that it was not deployed
+func isFailedOrPending(status release.Status) bool {
+	switch status {
+	case release.StatusFailed, release.StatusPendingInstall, release.StatusPendingUpgrade, release.StatusPendingRollback:
+		return true
+	}
+	return false
+}
+
+// isUninstalled returns whether a release version is uninstalled, or being uninstalled
+func isUninstalled(status release.Status) bool {
+	switch status {
+	case release.StatusUninstalled, release.StatusUninstalling:
+		return true
+	}
+	return false
+}
+
+// nextVersion returns the version of the release version added with the mapped APIs, which is after
+// the release version mapped and the release versions left after it
+func nextVersion(rel *release.Release, later []*release.Release) int {
+	if len(later) > 0 {
+		return later[len(later)-1].Version + 1
+	}
+	return rel.Version + 1
+}
+
+// deleteReleaseVersions deletes the release versions after the release version mapped.
+// It returns the release versions deleted, which are the ones deleted before the error if any.
+func deleteReleaseVersions(rels []*release.Release, cfg *action.Configuration) ([]*release.Release, error) {
+	for i, rel := range rels {
+		log.Printf("Delete %s release version '%s'.\n", rel.Info.Status, getReleaseVersionName(rel))
+		if _, err := cfg.Releases.Delete(rel.Name, rel.Version); err != nil {
+			return rels[:i], errors.Wrapf(err, "failed to delete release version '%s'", getReleaseVersionName(rel))
+		}
+		log.Printf("Release version '%s' deleted successfully.\n", getReleaseVersionName(rel))
+	}
+	return rels, nil
+}
+
+// recreateReleaseVersions stores the deleted release versions again after the release failed to be updated.
+// It returns the error which caused it wrapped with the release versions recreated, or the ones to restore
+// from the backup of the release.
+func recreateReleaseVersions(rels []*release.Release, cfg *action.Configuration, cause error) error {
+	if len(rels) == 0 {
+		return cause
+	}
+	var recreated, failed []string
+	for _, rel := range rels {
+		log.Printf("Recreate deleted release version '%s'.\n", getReleaseVersionName(rel))
+		if err := cfg.Releases.Create(rel); err != nil {
+			log.Printf("Failed to recreate release version '%s': %s\n", getReleaseVersionName(rel), err)
+			failed = append(failed, getReleaseVersionName(rel))
+			continue
+		}
+		recreated = append(recreated, getReleaseVersionName(rel))
+	}
+	if len(failed) > 0 {
+		return errors.Wrapf(cause, "failed to recreate deleted release versions '%s', restore them from the backup of the release",
+			strings.Join(failed, "', '"))
+	}
+	return errors.Wrapf(cause, "deleted release versions '%s' were recreated", strings.Join(recreated, "', '"))
+}
@@ -0,0 +1,15 @@
+#!/bin/sh -e
+
+# Helm post-renderer which maps the deprecated or removed Kubernetes APIs of the rendered manifest
+# with the plugin, e.g.:
+#
+#   helm upgrade my-release ./mychart --post-renderer "$HELM_PLUGINS/helm-mapkubeapis/scripts/post_renderer.sh"
+#
+# Helm runs post-renderers without arguments, so the flags of the post-render command are set with
+# the MAPKUBEAPIS_POST_RENDER_FLAGS environment variable, e.g. "--kube-context my-context --log-file mapkubeapis.log"
+
+HELM_PLUGIN_DIR="$(cd "$(dirname "$0")/.." && pwd)"
+export HELM_PLUGIN_DIR
+
+# shellcheck disable=SC2086
+exec "${HELM_PLUGIN_DIR}/bin/mapkubeapis" post-render ${MAPKUBEAPIS_POST_RENDER_FLAGS}